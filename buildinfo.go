@@ -0,0 +1,33 @@
+package configparser
+
+import "runtime/debug"
+
+// buildSetting returns the value of the build setting key, e.g. vcs.revision,
+// from the build info embedded in the binary. ok is false if there is no
+// build info or the setting isn't in it.
+func (o Options) buildSetting(key string) (val string, ok bool) {
+	readBuildInfo := o.readBuildInfo
+	if readBuildInfo == nil {
+		readBuildInfo = debug.ReadBuildInfo
+	}
+	info, ok := readBuildInfo()
+	if !ok {
+		return "", false
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == key {
+			return setting.Value, true
+		}
+	}
+	return "", false
+}
+
+// setFromBuildInfo sets the field to the build setting in its buildinfo tag,
+// if the binary has it.
+func (p *param) setFromBuildInfo(opts Options) error {
+	val, ok := opts.buildSetting(p.buildInfo)
+	if !ok {
+		return nil
+	}
+	return p.setParam(val, "build setting", p.buildInfo)
+}
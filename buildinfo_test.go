@@ -0,0 +1,49 @@
+package configparser
+
+import (
+	"runtime/debug"
+	"testing"
+)
+
+func TestBuildInfo(t *testing.T) {
+	type Config struct {
+		Version   string `noflag:"true" buildinfo:"vcs.revision" default:"dev"`
+		BuildTime string `noflag:"true" noenv:"true" buildinfo:"vcs.time"`
+		Modified  bool   `noflag:"true" noenv:"true" buildinfo:"vcs.modified"`
+	}
+
+	withSettings := func() (*debug.BuildInfo, bool) {
+		return &debug.BuildInfo{Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "0123abcd"},
+			{Key: "vcs.modified", Value: "true"},
+		}}, true
+	}
+	without := func() (*debug.BuildInfo, bool) {
+		return nil, false
+	}
+
+	tables := []struct {
+		readBuildInfo func() (*debug.BuildInfo, bool)
+		env           map[string]string
+		expected      Config
+	}{
+		{withSettings, map[string]string{}, Config{"0123abcd", "", true}},
+		{withSettings, map[string]string{"VERSION": "1.2.3"}, Config{"1.2.3", "", true}},
+		{without, map[string]string{}, Config{"dev", "", false}},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		pr := deterministicParser("", table.env, nil, Options{})
+		pr.opts.readBuildInfo = table.readBuildInfo
+
+		result := Config{}
+		if err := pr.Parse(&result); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package configparser
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ApplyDefaults sets every field of the struct pointed to by ptrtostruct which
+// has a default tag to its default value. No other sources are consulted, and
+// fields without a default tag are left untouched. Fields of types which need
+// a registered converter are skipped, as are fields with a layoutname tag. Use
+// Parser.ApplyDefaults for fields which depend on the parser's Options.
+func ApplyDefaults(ptrtostruct interface{}) error {
+	return NewParser().ApplyDefaults(ptrtostruct)
+}
+
+// ApplyDefaults behaves like the package-level ApplyDefaults, taking into
+// account the converters, Options.TimeLayouts and Options.EnumMaps in the
+// parser's Options.
+func (pr *Parser) ApplyDefaults(ptrtostruct interface{}) error {
+	structval, err := structValue(ptrtostruct)
+	if err != nil {
+		return err
+	}
+
+	structtype := structval.Type()
+	for i := 0; i < structtype.NumField(); i++ {
+		structfield := structtype.Field(i)
+		defaultval, ok := structfield.Tag.Lookup("default")
+		if !ok {
+			continue
+		}
+		p := optionsParam(structfield, structval.Field(i), pr.opts)
+		if p == nil {
+			continue
+		}
+		defaultval, _ = expandDefault(defaultval, os.LookupEnv, false)
+		if err := p.setValue(defaultval, "default value", structfield.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DiffFromDefaults reports the fields of the struct pointed to by ptrtostruct
+// which differ from their default values, typically after the struct has been
+// parsed. The returned map is keyed by field name, and holds the field's
+// current value in the same format it would be read from a config source.
+//
+// Fields with a default tag are compared against a copy of the struct which
+// has had ApplyDefaults called on it. Fields without a default tag are only
+// included if they are not the zero value. Use Parser.DiffFromDefaults for
+// fields which depend on the parser's Options.
+func DiffFromDefaults(ptrtostruct interface{}) (map[string]string, error) {
+	return NewParser().DiffFromDefaults(ptrtostruct)
+}
+
+// DiffFromDefaults behaves like the package-level DiffFromDefaults, using
+// Parser.ApplyDefaults.
+func (pr *Parser) DiffFromDefaults(ptrtostruct interface{}) (map[string]string, error) {
+	structval, err := structValue(ptrtostruct)
+	if err != nil {
+		return nil, err
+	}
+
+	structtype := structval.Type()
+	defaults := reflect.New(structtype)
+	if err := pr.ApplyDefaults(defaults.Interface()); err != nil {
+		return nil, err
+	}
+	defaultsval := defaults.Elem()
+
+	diff := make(map[string]string)
+	for i := 0; i < structtype.NumField(); i++ {
+		structfield := structtype.Field(i)
+		field := structval.Field(i)
+		p := optionsParam(structfield, field, pr.opts)
+		if p == nil {
+			continue
+		}
+
+		if _, ok := structfield.Tag.Lookup("default"); ok {
+			if p.value() != optionsParam(structfield, defaultsval.Field(i), pr.opts).value() {
+				diff[structfield.Name] = p.String()
+			}
+			continue
+		}
+		if !field.IsZero() {
+			diff[structfield.Name] = p.String()
+		}
+	}
+	return diff, nil
+}
+
+// expandDefault replaces each ${VAR} and ${VAR:-fallback} in val, the value of
+// a default tag, with the value of the environment variable VAR looked up
+// with lookup. The fallback is used if VAR is unset or empty. An unset VAR
+// without a fallback is replaced with an empty string, or is an error if
+// strict is true. Other uses of $, including a ${ without a closing brace, are
+// left alone.
+func expandDefault(val string, lookup func(string) (string, bool), strict bool) (string, error) {
+	var expanded strings.Builder
+	for {
+		start := strings.Index(val, "${")
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(val[start:], '}')
+		if end < 0 {
+			break
+		}
+		end += start
+		expanded.WriteString(val[:start])
+
+		name, fallback := val[start+2:end], ""
+		hasfallback := false
+		if i := strings.Index(name, ":-"); i >= 0 {
+			name, fallback, hasfallback = name[:i], name[i+2:], true
+		}
+		envval, ok := lookup(name)
+		switch {
+		case ok && envval != "":
+			expanded.WriteString(envval)
+		case hasfallback:
+			expanded.WriteString(fallback)
+		case !ok && strict:
+			return "", fmt.Errorf("refers to environment variable %s, which is not set", name)
+		}
+		val = val[end+1:]
+	}
+	expanded.WriteString(val)
+	return expanded.String(), nil
+}
+
+// structValue returns the struct pointed to by ptrtostruct.
+func structValue(ptrtostruct interface{}) (reflect.Value, error) {
+	ptrtostructval := reflect.ValueOf(ptrtostruct)
+	if ptrtostructval.Kind() == reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("argument must be a pointer to struct - got a %v struct passed by value instead; pass &yourStruct", ptrtostructval.Type())
+	}
+	if ptrtostructval.Kind() != reflect.Ptr {
+		return reflect.Value{}, fmt.Errorf("argument must be a pointer to struct - got %v instead", ptrtostructval.Kind())
+	}
+	structval := ptrtostructval.Elem()
+	if structval.Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("argument must be a pointer to struct - got a pointer to %v instead", structval.Kind())
+	}
+	return structval, nil
+}
+
+// optionsParam returns valueParam for a field of the struct being parsed,
+// along with the layout and enum names it gets from opts. It returns nil for a
+// field with a layoutname tag which is not in opts.TimeLayouts.
+func optionsParam(structfield reflect.StructField, field reflect.Value, opts Options) *param {
+	p := valueParam(structfield, field, opts.converters)
+	if p == nil {
+		return nil
+	}
+	if name, ok := structfield.Tag.Lookup("layoutname"); ok {
+		layout, ok := opts.TimeLayouts[name]
+		if !ok {
+			return nil
+		}
+		p.layout = layout
+	}
+	p.enum = opts.EnumMaps[structfield.Name]
+	return p
+}
+
+// valueParam returns a param which can be used to get or set field, taking
+// into account the tags which affect how its value is formatted. It returns
+// nil if the field is not of a type which is natively supported or has one of
+// converters, or if it cannot be set.
+func valueParam(structfield reflect.StructField, field reflect.Value, converters map[reflect.Type]Converter) *param {
+	if !isSupportedType(structfield.Type, converters) || !field.CanSet() || !field.CanAddr() {
+		return nil
+	}
+
+	layout := structfield.Tag.Get("layout")
+	if layout == "" {
+		layout = time.RFC3339
+	}
+	separator := structfield.Tag.Get("separator")
+	if separator == "" {
+		separator = ","
+	}
+	_, extendedduration := structfield.Tag.Lookup("extendedduration")
+	_, clockduration := structfield.Tag.Lookup("clockduration")
+	_, decimalcomma := structfield.Tag.Lookup("decimalcomma")
+	_, loglevel := structfield.Tag.Lookup("loglevel")
+	_, secret := structfield.Tag.Lookup("secret")
+	base := 8
+	if b, err := strconv.Atoi(structfield.Tag.Get("base")); err == nil {
+		base = b
+	}
+
+	encodings, _ := parseEncodings(structfield.Tag.Get("encoding"))
+
+	fieldtype := structfield.Type
+	if isPointerType(fieldtype) {
+		fieldtype = fieldtype.Elem()
+	}
+	parampointer, fieldpointer := fieldPointers(field)
+
+	return &param{
+		name:             structfield.Name,
+		fieldKind:        fieldtype.Kind(),
+		fieldType:        fieldtype,
+		paramPointer:     parampointer,
+		fieldPointer:     fieldpointer,
+		extendedDuration: extendedduration,
+		clockDuration:    clockduration,
+		layout:           layout,
+		unixTime:         structfield.Tag.Get("unixtime"),
+		base:             base,
+		unmarshalJSON:    implementsJSONUnmarshaler(fieldtype),
+		converter:        converterFor(fieldtype, converters),
+		converters:       converters,
+		encodings:        encodings,
+		strip:            structfield.Tag.Get("strip"),
+		decimalComma:     decimalcomma,
+		unit:             structfield.Tag.Get("unit"),
+		logLevel:         loglevel,
+		separator:        separator,
+		fileSeparator:    structfield.Tag.Get("filesep"),
+		envSeparator:     structfield.Tag.Get("envsep"),
+		secret:           secret,
+	}
+}
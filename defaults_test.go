@@ -0,0 +1,225 @@
+package configparser
+
+import (
+	"flag"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApplyDefaults(t *testing.T) {
+	type Config struct {
+		Host    string        `default:"localhost"`
+		Port    int           `default:"8080"`
+		Timeout time.Duration `default:"30s"`
+		Tags    []string      `default:"a,b"`
+		Name    string
+	}
+
+	result := Config{Name: "unchanged"}
+	if err := ApplyDefaults(&result); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := Config{"localhost", 8080, 30 * time.Second, []string{"a", "b"}, "unchanged"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %+v but got %+v instead", expected, result)
+	}
+
+	invalid := struct {
+		Port int `default:"http"`
+	}{}
+	if err := ApplyDefaults(&invalid); err == nil {
+		t.Error("Expected an error for an invalid default but did not get it")
+	}
+}
+
+func TestDiffFromDefaults(t *testing.T) {
+	type Config struct {
+		Host    string        `default:"localhost"`
+		Port    int           `default:"8080"`
+		Timeout time.Duration `default:"30s"`
+		Name    string
+		Debug   bool
+	}
+
+	tables := []struct {
+		flags    []string
+		expected map[string]string
+	}{
+		{[]string{}, map[string]string{}},
+		{[]string{"-port", "9090", "-name", "web"}, map[string]string{"Port": "9090", "Name": "web"}},
+		{[]string{"-timeout", "1m", "-host", "localhost"}, map[string]string{"Timeout": "1m0s"}},
+		{[]string{"-debug"}, map[string]string{"Debug": "true"}},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		result := Config{}
+		if err := Parse(&result); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		diff, err := DiffFromDefaults(&result)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if !reflect.DeepEqual(diff, table.expected) {
+			t.Errorf("Expected %v but got %v instead", table.expected, diff)
+		}
+	}
+
+	// A secret is reported as changed without its value.
+	secret := struct {
+		Password string `secret:"true" default:"changeme"`
+		Token    string `secret:"true" default:"abc"`
+	}{"hunter2", "abc"}
+	diff, err := DiffFromDefaults(&secret)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if expected := map[string]string{"Password": "****"}; !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Expected %v but got %v instead", expected, diff)
+	}
+
+	if _, err := DiffFromDefaults(Config{}); err == nil {
+		t.Error("Expected an error for a non-pointer argument but did not get it")
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestExpandDefault(t *testing.T) {
+	env := map[string]string{"DATA_DIR": "/data", "EMPTY": ""}
+	lookup := func(key string) (string, bool) {
+		val, ok := env[key]
+		return val, ok
+	}
+
+	tables := []struct {
+		input    string
+		strict   bool
+		expected string
+		isErr    bool
+	}{
+		{"${DATA_DIR:-/var/lib/app}/db", false, "/data/db", false},
+		{"${CACHE_DIR:-/var/cache/app}/db", false, "/var/cache/app/db", false},
+		{"${EMPTY:-fallback}", false, "fallback", false},
+		{"${DATA_DIR}/${DATA_DIR}", false, "/data//data", false},
+		{"${CACHE_DIR}/db", false, "/db", false},
+		{"${CACHE_DIR}/db", true, "", true},
+		{"${EMPTY}/db", true, "/db", false},
+		{`^\$[0-9]+$`, true, `^\$[0-9]+$`, false},
+		{"${UNTERMINATED", true, "${UNTERMINATED", false},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		expanded, err := expandDefault(table.input, lookup, table.strict)
+		if table.isErr {
+			if err == nil {
+				t.Errorf("Expected an error for %q but did not get it", table.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error expanding %q: %v", table.input, err)
+			continue
+		}
+		if expanded != table.expected {
+			t.Errorf("Expected %q but got %q instead", table.expected, expanded)
+		}
+	}
+}
+
+func TestDefaultExpansion(t *testing.T) {
+	type Config struct {
+		Database string `noenv:"true" default:"${DATA_DIR:-/var/lib/app}/db"`
+		Cache    string `noenv:"true" default:"${CACHE_DIR}/cache"`
+	}
+
+	tables := []struct {
+		env      map[string]string
+		opts     Options
+		expected Config
+		isErr    bool
+	}{
+		{map[string]string{"DATA_DIR": "/data", "CACHE_DIR": "/tmp"}, Options{}, Config{"/data/db", "/tmp/cache"}, false},
+		{map[string]string{}, Options{}, Config{"/var/lib/app/db", "/cache"}, false},
+		{map[string]string{}, Options{ErrorOnUnsetDefaultVars: true}, Config{}, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		pr := deterministicParser("", table.env, nil, table.opts)
+
+		result := Config{}
+		err := pr.Parse(&result)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+}
+
+func TestDefaultsWithOptions(t *testing.T) {
+	type Config struct {
+		Since time.Time `layoutname:"iso" default:"2021-01-02"`
+		Level int       `default:"high"`
+	}
+	opts := Options{
+		TimeLayouts: map[string]string{"iso": "2006-01-02"},
+		EnumMaps:    map[string]map[string]int{"Level": {"low": 1, "high": 2}},
+	}
+
+	result := Config{}
+	if err := NewParser().WithOptions(opts).ApplyDefaults(&result); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := Config{time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC), 2}
+	if result != expected {
+		t.Errorf("Expected %+v but got %+v instead", expected, result)
+	}
+
+	pr := deterministicParser("", nil, []string{"-level", "low"}, opts)
+	result = Config{}
+	if err := pr.Parse(&result); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	diff, err := pr.DiffFromDefaults(&result)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := map[string]string{"Level": "low"}; !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Expected %v but got %v instead", expected, diff)
+	}
+
+	// Without the options, a field with a layoutname tag is skipped.
+	since := struct {
+		Since time.Time `layoutname:"iso" default:"2021-01-02"`
+	}{}
+	if err := ApplyDefaults(&since); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !since.Since.IsZero() {
+		t.Errorf("Expected the field to be skipped but got %v", since.Since)
+	}
+}
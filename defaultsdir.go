@@ -0,0 +1,38 @@
+package configparser
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// defaultsDir returns Options.DefaultsDir, resolved against the directory
+// containing the executable if it is a relative path.
+func (o Options) defaultsDir() (string, error) {
+	if o.DefaultsDir == "" || filepath.IsAbs(o.DefaultsDir) {
+		return o.DefaultsDir, nil
+	}
+	exe, err := executable()
+	if err != nil {
+		return "", fmt.Errorf("could not locate the executable to find the defaults directory: %v", err)
+	}
+	return filepath.Join(filepath.Dir(exe), o.DefaultsDir), nil
+}
+
+// setFromDefaultsDir sets the field to the contents of the first of its
+// candidate files which exists in dir, read in the same way as a file in the
+// config directory. The field is left alone if none of them exist.
+func (p *param) setFromDefaultsDir(dir string, opts Options) error {
+	if p.fileExists {
+		return nil
+	}
+	for _, name := range p.fileCandidates() {
+		if name == "" || isGlob(name) {
+			continue
+		}
+		found, err := setParamFromFile(p, nil, filepath.Join(dir, filepath.FromSlash(name)), name, opts)
+		if err != nil || found {
+			return err
+		}
+	}
+	return nil
+}
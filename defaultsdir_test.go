@@ -0,0 +1,76 @@
+package configparser
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDefaultsDir(t *testing.T) {
+	type Config struct {
+		Host    string `default:"localhost"`
+		Port    int    `default:"8080"`
+		Name    string
+		Version string `buildinfo:"vcs.revision"`
+	}
+
+	defaults := t.TempDir()
+	for name, contents := range map[string]string{
+		"host":    "db.example.com\n",
+		"name":    "factory",
+		"version": "v0",
+	} {
+		if err := os.WriteFile(filepath.Join(defaults, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tables := []struct {
+		defaultsDir string
+		env         map[string]string
+		args        []string
+		expected    Config
+	}{
+		{"", map[string]string{}, []string{}, Config{"localhost", 8080, "", ""}},
+		{defaults, map[string]string{}, []string{}, Config{"db.example.com", 8080, "factory", "v0"}},
+		{defaults, map[string]string{"HOST": "env.example.com"}, []string{"-name", "web"}, Config{"env.example.com", 8080, "web", "v0"}},
+		{filepath.Join(defaults, "missing"), map[string]string{}, []string{}, Config{"localhost", 8080, "", ""}},
+		{filepath.Base(defaults), map[string]string{}, []string{}, Config{"db.example.com", 8080, "factory", "v0"}},
+	}
+
+	// A relative DefaultsDir is resolved against the executable's directory.
+	defer func(orig func() (string, error)) { executable = orig }(executable)
+	executable = func() (string, error) {
+		return filepath.Join(filepath.Dir(defaults), "app"), nil
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		pr := deterministicParser("", table.env, table.args, Options{DefaultsDir: table.defaultsDir})
+
+		result := Config{}
+		if err := pr.Parse(&result); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+
+	// Defaults files are read like config files, e.g. with filesep.
+	if err := os.WriteFile(filepath.Join(defaults, "tags"), []byte("a\nb\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pr := deterministicParser("", nil, nil, Options{DefaultsDir: defaults})
+	tags := struct {
+		Tags []string `filesep:"\n"`
+	}{}
+	if err := pr.Parse(&tags); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := []string{"a", "b"}; !reflect.DeepEqual(tags.Tags, expected) {
+		t.Errorf("Expected %v but got %v instead", expected, tags.Tags)
+	}
+}
@@ -0,0 +1,114 @@
+package configparser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GenerateMarkdownDocs returns a Markdown table describing each field of the
+// struct pointed to by ptrtostruct which ParseWithDir would set, with its
+// environment variable, command line flag, default value, example value,
+// whether it is mandatory, and its usage text. Fields are listed in the order
+// they are declared. It returns an empty string if ptrtostruct is not a
+// pointer to a struct.
+func GenerateMarkdownDocs(ptrtostruct interface{}) string {
+	structval, err := structValue(ptrtostruct)
+	if err != nil {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("| Field | Environment variable | Flag | Default | Example | Mandatory | Usage |\n")
+	b.WriteString("| --- | --- | --- | --- | --- | --- | --- |\n")
+
+	structtype := structval.Type()
+	for i := 0; i < structtype.NumField(); i++ {
+		structfield := structtype.Field(i)
+		if !structfield.IsExported() {
+			continue
+		}
+		_, hasenvindexed := structfield.Tag.Lookup("envindexed")
+		structelems := isStructSliceType(structfield.Type, nil)
+		if structelems && !hasenvindexed || !structelems && !isSupportedType(structfield.Type, nil) {
+			continue
+		}
+
+		var envvars []string
+		if envindexed := structfield.Tag.Get("envindexed"); structelems {
+			envvars = append(envvars, envindexed+"_N_*")
+		} else if envindexed != "" {
+			envvars = append(envvars, envindexed+"_N")
+		}
+		flagkey := ""
+		if !structelems {
+			if envkey := envKeyFor(structfield, ""); envkey != "" {
+				envvars = append(envvars, envkey)
+			}
+			if flagkey = flagKeyFor(structfield); flagkey != "" {
+				flagkey = "-" + flagkey
+			}
+		}
+
+		mandatory := "no"
+		if _, ok := structfield.Tag.Lookup("mandatory"); ok {
+			mandatory = "yes"
+		} else if mandatoryif := structfield.Tag.Get("mandatoryif"); mandatoryif != "" {
+			mandatory = "if " + mandatoryif + " is set"
+		}
+
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s | %s |\n",
+			markdownCell(structfield.Name),
+			markdownCell(strings.Join(envvars, ", ")),
+			markdownCell(flagkey),
+			markdownCell(structfield.Tag.Get("default")),
+			markdownCell(structfield.Tag.Get("example")),
+			markdownCell(mandatory),
+			markdownCell(structfield.Tag.Get("usage")))
+	}
+	return b.String()
+}
+
+// markdownCell formats s for use in a Markdown table cell, escaping the
+// characters which would break the table.
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.ReplaceAll(s, "\n", " ")
+}
+
+// PlannedFlags returns the names of the command line flags, without the
+// leading dash, which ParseWithDir would register for the struct pointed to
+// by ptrtostruct, in the order the fields are declared. Nothing is
+// registered, so the names can be checked for conflicts with other flags
+// beforehand. Fields of types which need a registered converter are left
+// out. It returns nil if ptrtostruct is not a pointer to a struct.
+func PlannedFlags(ptrtostruct interface{}) []string {
+	structval, err := structValue(ptrtostruct)
+	if err != nil {
+		return nil
+	}
+
+	flags := []string{}
+	structtype := structval.Type()
+	for i := 0; i < structtype.NumField(); i++ {
+		structfield := structtype.Field(i)
+		if !structfield.IsExported() || isStructSliceType(structfield.Type, nil) {
+			continue
+		}
+		if _, ok := structfield.Tag.Lookup("lazysecret"); ok && structfield.Type == lazySecretType {
+			continue
+		}
+		jsonstruct := structfield.Tag.Get("format") == "json" && isJSONStructType(structfield.Type, nil)
+		if !jsonstruct && !isSupportedType(structfield.Type, nil) {
+			continue
+		}
+		if tag, ok := structfield.Tag.Lookup("sources"); ok {
+			if sources, err := parseSources(tag); err == nil && !hasSource(sources, sourceFlag) {
+				continue
+			}
+		}
+		if flagkey := flagKeyFor(structfield); flagkey != "" {
+			flags = append(flags, flagkey)
+		}
+	}
+	return flags
+}
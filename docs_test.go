@@ -0,0 +1,109 @@
+package configparser
+
+import (
+	"flag"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestGenerateMarkdownDocs(t *testing.T) {
+	type Upstream struct {
+		Host string
+	}
+	type Config struct {
+		Hostname  string     `env:"HOST" flag:"host" default:"localhost" usage:"host to listen on"`
+		Port      int        `default:"8080" example:"9090" mandatory:"true"`
+		TLSCert   string     `noflag:"true" mandatoryif:"TLSEnabled" usage:"a | b"`
+		Upstreams []Upstream `envindexed:"UPSTREAM"`
+		Ignored   complex128
+		private   string
+	}
+
+	docs := GenerateMarkdownDocs(&Config{})
+	t.Log(docs)
+
+	lines := strings.Split(strings.TrimSpace(docs), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("Expected a header, a separator and 4 rows but got %d lines", len(lines))
+	}
+	expected := []string{
+		"| Hostname | HOST | -host | localhost |  | no | host to listen on |",
+		"| Port | PORT | -port | 8080 | 9090 | yes |  |",
+		`| TLSCert | TLSCERT |  |  |  | if TLSEnabled is set | a \| b |`,
+		"| Upstreams | UPSTREAM_N_* |  |  |  | no |  |",
+	}
+	for i, row := range expected {
+		if lines[i+2] != row {
+			t.Errorf("Expected row %q but got %q instead", row, lines[i+2])
+		}
+	}
+
+	// The example is only documented, so the default is still used.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	setFlags([]string{})
+	parsed := struct {
+		Port int `default:"8080" example:"9090"`
+	}{}
+	if err := Parse(&parsed); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	} else if parsed.Port != 8080 {
+		t.Errorf("Expected Port to be 8080 but got %d instead", parsed.Port)
+	}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	if docs := GenerateMarkdownDocs(Config{}); docs != "" {
+		t.Errorf("Expected no docs for a struct passed by value but got %q", docs)
+	}
+}
+
+func TestPlannedFlags(t *testing.T) {
+	type Upstream struct {
+		Host string
+	}
+	type Config struct {
+		Hostname  string `flag:"host"`
+		Port      int
+		TLSCert   string                 `noflag:"true"`
+		Upstreams []Upstream             `envindexed:"UPSTREAM"`
+		Region    string                 `sources:"env,default"`
+		Zone      string                 `sources:"flag,env"`
+		Token     func() (string, error) `lazysecret:"true"`
+		Ignored   complex128
+		private   string
+	}
+
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	planned := PlannedFlags(&Config{})
+	expected := []string{"host", "port", "zone"}
+	if !reflect.DeepEqual(planned, expected) {
+		t.Errorf("Expected %v but got %v instead", expected, planned)
+	}
+	if flag.CommandLine.Lookup("host") != nil {
+		t.Error("Expected PlannedFlags not to register any flags")
+	}
+
+	// The planned flags are the ones which Parse registers.
+	if err := Parse(&Config{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var registered []string
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		registered = append(registered, f.Name)
+	})
+	sort.Strings(registered)
+	if !reflect.DeepEqual(registered, expected) {
+		t.Errorf("Expected Parse to register %v but got %v instead", expected, registered)
+	}
+
+	if planned := PlannedFlags(Config{}); planned != nil {
+		t.Errorf("Expected no flags for a struct passed by value but got %v", planned)
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
@@ -0,0 +1,205 @@
+package configparser
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// ParseReader will decode a single structured document from r and use it to
+// set the fields in the struct pointed to by ptrtostruct. Environment
+// variables and command line flags are then applied on top of the document,
+// in the same way as Parse.
+//
+// format selects the decoder used for the document. The supported formats are
+// json and flat. A flat document has one key=value pair per line - blank lines
+// and lines starting with # are ignored. A document value takes precedence
+// over the field's default tag, but environment variables and command line
+// flags take precedence over the document.
+//
+// Document keys are matched to fields using the name in the field's json tag,
+// or the field name if there is no json tag. Keys are matched
+// case-insensitively.
+func ParseReader(ptrtostruct interface{}, r io.Reader, format string) error {
+	return NewParser().ParseReader(ptrtostruct, r, format)
+}
+
+// ParseReader behaves like the package-level ParseReader, using the parser's
+// configuration. If the parser has a config directory, files take precedence
+// over the document as well.
+func (pr *Parser) ParseReader(ptrtostruct interface{}, r io.Reader, format string) error {
+	document, err := decodeDocument(r, format)
+	if err != nil {
+		return pr.complete(err)
+	}
+	return pr.parse(ptrtostruct, document)
+}
+
+// ParseFromURL will fetch a structured document with an HTTP GET request to
+// url and use it to set the fields in the struct pointed to by ptrtostruct, in
+// the same way as ParseReader. This allows config to be served centrally, with
+// environment variables and command line flags still able to override it.
+//
+// A response with a status other than 200 OK is treated as an error, as is any
+// error while fetching the document. See Options.HTTPClient and
+// Options.HTTPTimeout to customize the request.
+func ParseFromURL(ptrtostruct interface{}, url string, format string) error {
+	return NewParser().ParseFromURL(ptrtostruct, url, format)
+}
+
+// ParseFromURL behaves like the package-level ParseFromURL, using the parser's
+// configuration.
+func (pr *Parser) ParseFromURL(ptrtostruct interface{}, url string, format string) error {
+	document, err := pr.fetchDocument(url, format)
+	if err != nil {
+		return pr.complete(err)
+	}
+	return pr.parse(ptrtostruct, document)
+}
+
+// ParseWithEmbeddedDefaults behaves like ParseWithDir, but first sets the
+// fields from defaults, a document in the given format as for ParseReader.
+// This allows default config to be embedded in the binary, e.g. with
+// //go:embed, while files in dir, environment variables and command line flags
+// still override it. Values in defaults take precedence over default tags.
+func ParseWithEmbeddedDefaults(ptrtostruct interface{}, defaults []byte, format string, dir string) error {
+	pr := NewParser().WithDir(dir)
+	document, err := decodeDocument(bytes.NewReader(defaults), format)
+	if err != nil {
+		return pr.complete(fmt.Errorf("error reading embedded defaults: %w", err))
+	}
+	return pr.parse(ptrtostruct, document)
+}
+
+// fetchDocument fetches and decodes the document for ParseFromURL.
+func (pr *Parser) fetchDocument(url string, format string) (map[string]string, error) {
+	ctx := context.Background()
+	if pr.opts.HTTPTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, pr.opts.HTTPTimeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %s: %w", url, err)
+	}
+	client := pr.opts.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching %s: unexpected status %s", url, resp.Status)
+	}
+
+	document, err := decodeDocument(resp.Body, format)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", url, err)
+	}
+	return document, nil
+}
+
+// ParseFlatFile will read a flat document from the file at filename and use it
+// to set the fields in the struct pointed to by ptrtostruct, in the same way as
+// ParseReader.
+//
+// If prefix is not empty, only keys starting with prefix are used, with the
+// prefix stripped before the key is matched to a field. This allows several
+// applications to share a file, e.g. with a prefix of "myapp.", the key
+// myapp.port sets the Port field and the key otherapp.port is ignored.
+// Prefixes are matched case-insensitively.
+func ParseFlatFile(ptrtostruct interface{}, filename, prefix string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	document, err := decodeFlatDocument(f, prefix)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", filename, err)
+	}
+	return NewParser().parse(ptrtostruct, document)
+}
+
+// decodeDocument decodes a document in the given format into a map of
+// lowercase keys to string values.
+func decodeDocument(r io.Reader, format string) (map[string]string, error) {
+	switch strings.ToLower(format) {
+	case "json":
+		return decodeJSONDocument(r)
+	case "flat":
+		return decodeFlatDocument(r, "")
+	}
+	return nil, fmt.Errorf("unsupported document format %q", format)
+}
+
+// decodeFlatDocument decodes key=value lines, keeping only the keys which
+// start with prefix and stripping prefix from them.
+func decodeFlatDocument(r io.Reader, prefix string) (map[string]string, error) {
+	prefix = strings.ToLower(prefix)
+	document := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.IndexByte(line, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("line %d is not of the form key=value: %s", lineno, line)
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		document[strings.TrimPrefix(key, prefix)] = strings.TrimSpace(line[i+1:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+func decodeJSONDocument(r io.Reader) (map[string]string, error) {
+	var raw map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("error decoding json document: %v", err)
+	}
+
+	document := make(map[string]string)
+	for k, v := range raw {
+		if string(v) == "null" {
+			continue
+		}
+
+		// Strings are unquoted - everything else (numbers, bools, objects
+		// and arrays) is passed through as raw JSON text.
+		var s string
+		if err := json.Unmarshal(v, &s); err == nil {
+			document[strings.ToLower(k)] = s
+			continue
+		}
+		document[strings.ToLower(k)] = string(v)
+	}
+	return document, nil
+}
+
+// documentKey returns the key used to look up a field in a decoded document.
+func documentKey(structfield reflect.StructField) string {
+	if name := strings.Split(structfield.Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+		return strings.ToLower(name)
+	}
+	return strings.ToLower(structfield.Name)
+}
@@ -0,0 +1,298 @@
+package configparser
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseReader(t *testing.T) {
+	type Config struct {
+		Hostname string `env:"HOST" flag:"host" default:"localhost"`
+		Port     int    `json:"listen_port" default:"8080"`
+		Async    bool
+		Timeout  string `default:"30s"`
+	}
+
+	tables := []struct {
+		document string
+		format   string
+		flags    []string
+		env      []string
+		expected Config
+		isErr    bool
+	}{
+		{`{"hostname":"doc","listen_port":9000,"async":true}`, "json", []string{}, []string{"", "", ""}, Config{"doc", 9000, true, "30s"}, false},           // document overrides defaults
+		{`{"hostname":"doc","listen_port":9000}`, "JSON", []string{"-host", "flag"}, []string{"", "", ""}, Config{"flag", 9000, false, "30s"}, false},       // flag overrides document
+		{`{"hostname":"doc","listen_port":9000}`, "json", []string{"-host", "flag"}, []string{"env", "7000", ""}, Config{"env", 7000, false, "30s"}, false}, // env overrides document and flag
+		{`{"Hostname":"doc","timeout":null}`, "json", []string{}, []string{"", "", ""}, Config{"doc", 8080, false, "30s"}, false},                           // keys are case-insensitive and null is ignored
+		{`{"listen_port":"abc"}`, "json", []string{}, []string{"", "", ""}, Config{}, true},                                                                 // document value of the wrong type
+		{`{"hostname":`, "json", []string{}, []string{"", "", ""}, Config{}, true},                                                                          // malformed document
+		{`hostname: doc`, "yaml", []string{}, []string{"", "", ""}, Config{}, true},                                                                         // unknown format
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+		setConfigEnv(table.env)
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		result := Config{}
+		err := ParseReader(&result, strings.NewReader(table.document), table.format)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+
+		if result != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+
+	// The document is a source for a mandatory field without an environment
+	// variable or flag.
+	type Mandatory struct {
+		Host string `noenv:"true" noflag:"true" mandatory:"true"`
+	}
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	mandatory := Mandatory{}
+	if err := ParseReader(&mandatory, strings.NewReader(`{"host":"x"}`), "json"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	} else if mandatory.Host != "x" {
+		t.Errorf("Expected x but got %q instead", mandatory.Host)
+	}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	var missing *MissingMandatoryError
+	if err := ParseReader(&Mandatory{}, strings.NewReader(`{}`), "json"); !errors.As(err, &missing) {
+		t.Errorf("Expected a MissingMandatoryError but got %v", err)
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestParseFlatFile(t *testing.T) {
+	contents := `# shared config
+myapp.hostname = myhost
+otherapp.hostname = otherhost
+MyApp.Port=9000
+
+otherapp.port=1234
+myapp.async=true
+`
+	dir, err := os.MkdirTemp("", "configparser-test")
+	if err != nil {
+		t.Errorf("Could not create temp dir: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "shared.conf")
+	if err := os.WriteFile(filename, []byte(contents), 0644); err != nil {
+		t.Errorf("Could not write flat file: %v", err)
+		return
+	}
+
+	type Config struct {
+		Hostname string `env:"HOST" flag:"host"`
+		Port     int    `default:"8080"`
+		Async    bool
+	}
+
+	setFlags([]string{})
+	setConfigEnv([]string{"", "", ""})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	result := Config{}
+	if err := ParseFlatFile(&result, filename, "myapp."); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	expected := Config{"myhost", 9000, true}
+	if result != expected {
+		t.Errorf("Expected %+v but got %+v instead", expected, result)
+	}
+
+	// Without a prefix, no keys match any fields.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	result = Config{}
+	if err := ParseReader(&result, strings.NewReader(contents), "flat"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	expected = Config{"", 8080, false}
+	if result != expected {
+		t.Errorf("Expected %+v but got %+v instead", expected, result)
+	}
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	if err := ParseReader(&result, strings.NewReader("myapp.port"), "flat"); err == nil {
+		t.Error("Expected an error for a malformed line but did not get it")
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestParseFromURL(t *testing.T) {
+	type Config struct {
+		Hostname string `env:"HOST" flag:"host" default:"localhost"`
+		Port     int    `json:"listen_port" default:"8080"`
+		Async    bool
+		Timeout  string `default:"30s"`
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/config.json":
+			fmt.Fprint(w, `{"hostname":"remote","listen_port":9000}`)
+		case "/slow.json":
+			time.Sleep(200 * time.Millisecond)
+			fmt.Fprint(w, `{}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tables := []struct {
+		path     string
+		flags    []string
+		timeout  time.Duration
+		expected Config
+		isErr    bool
+	}{
+		{"/config.json", []string{}, 0, Config{"remote", 9000, false, "30s"}, false},              // document overrides defaults
+		{"/config.json", []string{"-host", "flag"}, 0, Config{"flag", 9000, false, "30s"}, false}, // flag overrides document
+		{"/missing.json", []string{}, 0, Config{}, true},                                          // status other than 200
+		{"/slow.json", []string{}, 50 * time.Millisecond, Config{}, true},                         // timeout
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+		setConfigEnv([]string{"", "", ""})
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		result := Config{}
+		opts := Options{HTTPClient: server.Client(), HTTPTimeout: table.timeout}
+		err := NewParser().WithOptions(opts).ParseFromURL(&result, server.URL+table.path, "json")
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+
+		if result != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+
+	// Network errors are wrapped so the cause can be inspected.
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	url := server.URL
+	server.Close()
+	err := ParseFromURL(&Config{}, url+"/config.json", "json")
+	var urlErr *neturl.Error
+	if !errors.As(err, &urlErr) {
+		t.Errorf("Expected a wrapped *url.Error but got %v instead", err)
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestParseWithEmbeddedDefaults(t *testing.T) {
+	type Config struct {
+		Hostname string `env:"HOST" flag:"host" default:"localhost"`
+		Port     int    `json:"listen_port" default:"8080"`
+		Async    bool
+		Username string `default:"nobody"`
+	}
+
+	defaults := []byte(`{"hostname":"embedded","listen_port":9000,"username":"embedded"}`)
+
+	dir, err := createFilesInTempDir(map[string]configFile{
+		"username": {contents: "admin"},
+	})
+	if err != nil {
+		t.Errorf("Could not create files in temp dir: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	tables := []struct {
+		defaults []byte
+		format   string
+		flags    []string
+		env      []string
+		expected Config
+		isErr    bool
+	}{
+		{defaults, "json", []string{}, []string{"", "", ""}, Config{"embedded", 9000, false, "admin"}, false},            // files override embedded defaults
+		{defaults, "json", []string{"-host", "flag"}, []string{"", "", ""}, Config{"flag", 9000, false, "admin"}, false}, // flag overrides embedded defaults
+		{defaults, "json", []string{}, []string{"env", "7000", "true"}, Config{"env", 7000, true, "admin"}, false},       // env overrides embedded defaults
+		{[]byte(`{}`), "json", []string{}, []string{"", "", ""}, Config{"localhost", 8080, false, "admin"}, false},       // default tags apply without embedded defaults
+		{defaults, "toml", []string{}, []string{"", "", ""}, Config{}, true},                                             // unknown format
+		{[]byte(`{"listen_port":`), "json", []string{}, []string{"", "", ""}, Config{}, true},                            // malformed embedded defaults
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+		setConfigEnv(table.env)
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		result := Config{}
+		err := ParseWithEmbeddedDefaults(&result, table.defaults, table.format, dir)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+
+		if result != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+
+	setConfigEnv([]string{"", "", ""})
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
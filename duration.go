@@ -0,0 +1,129 @@
+package configparser
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// extendedUnits are the duration units understood by parseExtendedDuration
+// on top of the ones understood by time.ParseDuration.
+var extendedUnits = map[string]time.Duration{
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+// parseExtendedDuration parses a duration string in the same format as
+// time.ParseDuration, but additionally accepts the d (day) and w (week) units,
+// e.g. "30d" or "1w2d12h".
+func parseExtendedDuration(s string) (time.Duration, error) {
+	orig := s
+	neg := false
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	if s == "0" {
+		return 0, nil
+	}
+	if s == "" {
+		return 0, fmt.Errorf("invalid duration %q", orig)
+	}
+
+	var total time.Duration
+	for s != "" {
+		// Consume the number.
+		i := 0
+		for i < len(s) && (s[i] == '.' || (s[i] >= '0' && s[i] <= '9')) {
+			i++
+		}
+		number := s[:i]
+		s = s[i:]
+
+		// Consume the unit, which is everything up to the next number.
+		i = 0
+		for i < len(s) && s[i] != '.' && (s[i] < '0' || s[i] > '9') {
+			i++
+		}
+		unit := s[:i]
+		s = s[i:]
+
+		if number == "" || unit == "" {
+			return 0, fmt.Errorf("invalid duration %q", orig)
+		}
+
+		if multiplier, ok := extendedUnits[unit]; ok {
+			f, err := strconv.ParseFloat(number, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", orig)
+			}
+			// A float64 of math.MaxInt64 rounds up to 2^63, which is out of
+			// range itself.
+			v := f * float64(multiplier)
+			if v >= float64(math.MaxInt64) {
+				return 0, fmt.Errorf("duration %q is out of range", orig)
+			}
+			d := time.Duration(v)
+			if total > math.MaxInt64-d {
+				return 0, fmt.Errorf("duration %q is out of range", orig)
+			}
+			total += d
+			continue
+		}
+
+		d, err := time.ParseDuration(number + unit)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", orig)
+		}
+		if total > math.MaxInt64-d {
+			return 0, fmt.Errorf("duration %q is out of range", orig)
+		}
+		total += d
+	}
+
+	if neg {
+		return -total, nil
+	}
+	return total, nil
+}
+
+// parseClockDuration parses a duration written as clock time, i.e. HH:MM:SS
+// or MM:SS, e.g. "01:30:00" is 90 minutes. The first part may be any number
+// of digits, but the minutes and seconds which follow it must be less than
+// 60. The seconds may have a fractional part, e.g. "00:01.5".
+func parseClockDuration(s string) (time.Duration, error) {
+	orig := s
+	neg := false
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid clock duration %q", orig)
+	}
+
+	var total time.Duration
+	units := []time.Duration{time.Hour, time.Minute, time.Second}[3-len(parts):]
+	for i, part := range parts {
+		if part == "" || strings.IndexFunc(part, func(r rune) bool { return (r < '0' || r > '9') && r != '.' }) >= 0 {
+			return 0, fmt.Errorf("invalid clock duration %q", orig)
+		}
+		// Only the seconds may have a fractional part.
+		if i < len(parts)-1 && strings.Contains(part, ".") {
+			return 0, fmt.Errorf("invalid clock duration %q", orig)
+		}
+		f, err := strconv.ParseFloat(part, 64)
+		if err != nil || (i > 0 && f >= 60) {
+			return 0, fmt.Errorf("invalid clock duration %q", orig)
+		}
+		total += time.Duration(f * float64(units[i]))
+	}
+
+	if neg {
+		return -total, nil
+	}
+	return total, nil
+}
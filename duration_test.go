@@ -0,0 +1,224 @@
+package configparser
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseExtendedDuration(t *testing.T) {
+	tables := []struct {
+		input    string
+		expected time.Duration
+		isErr    bool
+	}{
+		{"30d", 30 * 24 * time.Hour, false},
+		{"2w", 14 * 24 * time.Hour, false},
+		{"1w2d12h", 9*24*time.Hour + 12*time.Hour, false},
+		{"1.5d", 36 * time.Hour, false},
+		{"-1d", -24 * time.Hour, false},
+		{"90m", 90 * time.Minute, false},
+		{"1h30m15s", time.Hour + 30*time.Minute + 15*time.Second, false},
+		{"0", 0, false},
+		{"", 0, true},
+		{"d", 0, true},
+		{"30", 0, true},
+		{"3y", 0, true},
+		{"300000w", 0, true},
+		{"15000w2562047h", 0, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		d, err := parseExtendedDuration(table.input)
+		if table.isErr {
+			if err == nil {
+				t.Errorf("Expected an error for %q but did not get it", table.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %v", table.input, err)
+			continue
+		}
+		if d != table.expected {
+			t.Errorf("Expected %v but got %v instead", table.expected, d)
+		}
+	}
+}
+
+func TestParseClockDuration(t *testing.T) {
+	tables := []struct {
+		input    string
+		expected time.Duration
+		isErr    bool
+	}{
+		{"01:30:00", 90 * time.Minute, false},
+		{"1:30:00", 90 * time.Minute, false},
+		{"100:00:05", 100*time.Hour + 5*time.Second, false},
+		{"05:30", 5*time.Minute + 30*time.Second, false},
+		{"00:01.5", 1500 * time.Millisecond, false},
+		{"-00:10:00", -10 * time.Minute, false},
+		{"01:60:00", 0, true},
+		{"01:30:", 0, true},
+		{"1:2:3:4", 0, true},
+		{"1.5:00", 0, true},
+		{"1h:30", 0, true},
+		{"90", 0, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		d, err := parseClockDuration(table.input)
+		if table.isErr {
+			if err == nil {
+				t.Errorf("Expected an error for %q but did not get it", table.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %v", table.input, err)
+			continue
+		}
+		if d != table.expected {
+			t.Errorf("Expected %v but got %v instead", table.expected, d)
+		}
+	}
+}
+
+func TestClockDurationFields(t *testing.T) {
+	type Config struct {
+		Timeout time.Duration `clockduration:"true" noenv:"true"`
+	}
+
+	tables := []struct {
+		flags    []string
+		expected time.Duration
+		isErr    bool
+	}{
+		{[]string{"-timeout", "01:30:00"}, 90 * time.Minute, false},
+		{[]string{"-timeout", "02:15"}, 2*time.Minute + 15*time.Second, false},
+		{[]string{"-timeout", "45s"}, 45 * time.Second, false},
+		{[]string{"-timeout", "01:75:00"}, 0, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		flag.CommandLine.SetOutput(new(bytes.Buffer))
+
+		result := Config{}
+		err := Parse(&result)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else if !strings.Contains(err.Error(), "Timeout") {
+				t.Errorf("Expected an error naming the field but got: %v", err)
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result.Timeout != table.expected {
+			t.Errorf("Expected %v but got %v instead", table.expected, result.Timeout)
+		}
+	}
+
+	invalid := struct {
+		Timeout int `clockduration:"true"`
+	}{}
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	if err := Parse(&invalid); err == nil {
+		t.Error("Expected an error for a clockduration tag on an int field but did not get it")
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestDurationFields(t *testing.T) {
+	config := struct {
+		Timeout         time.Duration `default:"30s"`
+		RetentionPeriod time.Duration `extendedduration:"true"`
+	}{}
+
+	setFlags([]string{"-timeout", "5m"})
+	os.Setenv("RETENTIONPERIOD", "30d")
+	defer os.Unsetenv("RETENTIONPERIOD")
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	if err := Parse(&config); err != nil {
+		t.Errorf("Unexpected error while parsing: %v", err)
+	}
+	if config.Timeout != 5*time.Minute {
+		t.Errorf("Expected timeout %v but got %v instead", 5*time.Minute, config.Timeout)
+	}
+	if config.RetentionPeriod != 30*24*time.Hour {
+		t.Errorf("Expected retention period %v but got %v instead", 30*24*time.Hour, config.RetentionPeriod)
+	}
+
+	// Without the extendedduration tag, days are not understood.
+	os.Setenv("TIMEOUT", "1d")
+	defer os.Unsetenv("TIMEOUT")
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	if err := Parse(&config); err == nil {
+		t.Error("Expected an error but did not get it")
+	} else {
+		t.Logf("Expected an error - got: %v", err)
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestDurationSources(t *testing.T) {
+	dir, err := createFilesInTempDir(map[string]configFile{
+		"readtimeout": {contents: "2m30s"},
+		"badtimeout":  {contents: "5"},
+	})
+	if err != nil {
+		t.Errorf("Could not create files in temp dir: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	type Config struct {
+		Timeout      time.Duration `default:"30s"`
+		ReadTimeout  time.Duration
+		WriteTimeout time.Duration
+		IdleTimeout  time.Duration
+	}
+
+	pr := deterministicParser(dir, map[string]string{"WRITETIMEOUT": "1h"}, []string{"-idletimeout", "90s"}, Options{})
+
+	result := Config{}
+	if err := pr.Parse(&result); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := Config{30 * time.Second, 150 * time.Second, time.Hour, 90 * time.Second}
+	if result != expected {
+		t.Errorf("Expected %+v but got %+v instead", expected, result)
+	}
+
+	// A number without a unit is not a duration.
+	bad := struct {
+		BadTimeout time.Duration
+	}{}
+	pr = deterministicParser(dir, nil, nil, Options{})
+	err = pr.Parse(&bad)
+	if err == nil || !strings.Contains(err.Error(), "field BadTimeout must be a duration") {
+		t.Errorf("Expected an error saying the field must be a duration but got: %v", err)
+	}
+}
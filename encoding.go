@@ -0,0 +1,84 @@
+package configparser
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Encodings which can be specified in the encoding tag.
+const (
+	encodingBase64 = "base64"
+	encodingGzip   = "gzip"
+	encodingHex    = "hex"
+)
+
+// parseEncodings splits the value of an encoding tag into its encodings,
+// returning an error if any of them is unknown.
+func parseEncodings(tag string) ([]string, error) {
+	encodings := strings.Split(tag, ",")
+	for i := range encodings {
+		encodings[i] = strings.TrimSpace(encodings[i])
+		switch encodings[i] {
+		case encodingBase64, encodingGzip, encodingHex:
+		default:
+			return nil, fmt.Errorf("unknown encoding %q", encodings[i])
+		}
+	}
+	return encodings, nil
+}
+
+// decodeContents reverses each of encodings in turn, so that
+// []string{"base64", "gzip"} base64-decodes b and then decompresses the
+// result.
+func decodeContents(b []byte, encodings []string) ([]byte, error) {
+	for _, encoding := range encodings {
+		var err error
+		switch encoding {
+		case encodingBase64:
+			b, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+		case encodingGzip:
+			b, err = gunzip(b)
+		case encodingHex:
+			b, err = hex.DecodeString(strings.TrimSpace(string(b)))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s data: %v", encoding, err)
+		}
+	}
+	return b, nil
+}
+
+// encodeBytes encodes b with encoding, which must be base64 or hex.
+func encodeBytes(b []byte, encoding string) string {
+	if encoding == encodingBase64 {
+		return base64.StdEncoding.EncodeToString(b)
+	}
+	return hex.EncodeToString(b)
+}
+
+// byteArrayEncoding returns the encoding of the values of a byte array field
+// with the given encoding tag, which defaults to hex. It returns an error if
+// the tag doesn't specify exactly one of hex and base64.
+func byteArrayEncoding(encodings []string) (string, error) {
+	if len(encodings) == 0 {
+		return encodingHex, nil
+	}
+	if len(encodings) > 1 || encodings[0] == encodingGzip {
+		return "", fmt.Errorf("byte arrays must have an encoding of either %s or %s", encodingHex, encodingBase64)
+	}
+	return encodings[0], nil
+}
+
+func gunzip(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
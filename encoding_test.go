@@ -0,0 +1,132 @@
+package configparser
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"flag"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEncoding(t *testing.T) {
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	w.Write([]byte("a large templated blob"))
+	w.Close()
+
+	filevalues := map[string]configFile{
+		"template": {contents: compressed.String()},
+		"secret":   {contents: base64.StdEncoding.EncodeToString(compressed.Bytes()) + "\n"},
+		"token":    {contents: base64.StdEncoding.EncodeToString([]byte("s3cr3t"))},
+	}
+	dir, err := createFilesInTempDir(filevalues)
+	if err != nil {
+		t.Fatalf("Could not create files in temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := struct {
+		Template string `encoding:"gzip"`
+		Secret   string `encoding:"base64,gzip"`
+		Token    string `encoding:"base64"`
+	}{}
+
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	if err := ParseWithDir(&config, dir); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.Template != "a large templated blob" {
+		t.Errorf("Unexpected template: %q", config.Template)
+	}
+	if config.Secret != "a large templated blob" {
+		t.Errorf("Unexpected secret: %q", config.Secret)
+	}
+	if config.Token != "s3cr3t" {
+		t.Errorf("Unexpected token: %q", config.Token)
+	}
+
+	// Data which isn't gzipped results in an error naming the field.
+	invalid := struct {
+		Token string `encoding:"gzip"`
+	}{}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	if err := ParseWithDir(&invalid, dir); err == nil {
+		t.Error("Expected an error for invalid gzip data but did not get it")
+	} else if !strings.Contains(err.Error(), "Token") {
+		t.Errorf("Expected the error to name the field but got: %v", err)
+	}
+
+	unknown := struct {
+		Token string `encoding:"rot13"`
+	}{}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	if err := ParseWithDir(&unknown, dir); err == nil {
+		t.Error("Expected an error for an unknown encoding but did not get it")
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestByteArrays(t *testing.T) {
+	type Config struct {
+		Key  [4]byte
+		Salt [4]byte `encoding:"base64"`
+	}
+
+	tables := []struct {
+		flags    []string
+		expected Config
+		isErr    bool
+	}{
+		{[]string{"-key", "deadbeef", "-salt", "AQIDBA=="}, Config{[4]byte{0xde, 0xad, 0xbe, 0xef}, [4]byte{1, 2, 3, 4}}, false},
+		{[]string{"-key", "deadbe"}, Config{}, true},     // too short
+		{[]string{"-key", "deadbeef00"}, Config{}, true}, // too long
+		{[]string{"-salt", "AQID"}, Config{}, true},      // too short
+		{[]string{"-key", "xyz"}, Config{}, true},        // not hex
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		stderr := new(bytes.Buffer)
+		flag.CommandLine.SetOutput(stderr)
+
+		result := Config{}
+		err := Parse(&result)
+		if table.isErr {
+			if stderr.Len() == 0 {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", stderr.String())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+
+	// A byte array can't be gzipped.
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	invalid := struct {
+		Key [4]byte `encoding:"gzip"`
+	}{}
+	if err := Parse(&invalid); err == nil {
+		t.Error("Expected an error for a gzip encoding on a byte array but did not get it")
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
@@ -0,0 +1,83 @@
+package configparser
+
+import (
+	"os"
+	"strings"
+)
+
+// EnvMap returns the environment variables whose names start with prefix,
+// keyed by their lowercased names with prefix removed. For example, with a
+// prefix of "MYAPP_", MYAPP_PORT=8080 is returned as "port": "8080". The
+// prefix is matched case-sensitively, and a variable named exactly prefix is
+// ignored. Unlike ParseWithDir, EnvMap does not need a struct and does not
+// touch the command line flags.
+func EnvMap(prefix string) map[string]string {
+	m := make(map[string]string)
+	for _, kv := range os.Environ() {
+		i := strings.IndexByte(kv, '=')
+		if i < 0 {
+			continue
+		}
+		key := kv[:i]
+		if !strings.HasPrefix(key, prefix) || len(key) == len(prefix) {
+			continue
+		}
+		m[strings.ToLower(key[len(prefix):])] = kv[i+1:]
+	}
+	return m
+}
+
+// lookupEnv looks up the environment variable key in the environment
+// configured in o, which is the process environment unless
+// ParseDeterministic was used.
+func (o Options) lookupEnv(key string) (string, bool) {
+	if o.env != nil {
+		val, ok := o.env[key]
+		return val, ok
+	}
+	return os.LookupEnv(key)
+}
+
+// lookupEnv looks up the field's environment variable, falling back to its
+// name with Options.LegacyEnvPrefix. key is the name of the variable which
+// was found.
+func (p param) lookupEnv(opts Options) (key, val string, ok bool) {
+	if val, ok := opts.lookupEnv(p.envKey); ok {
+		return p.envKey, val, true
+	}
+	if p.legacyEnvKey != "" {
+		if val, ok := opts.lookupEnv(p.legacyEnvKey); ok {
+			return p.legacyEnvKey, val, true
+		}
+	}
+	return "", "", false
+}
+
+// setFromEnvJoin sets the field to the values of the environment variables in
+// its envjoin tag which are set, joined with its joinsep tag.
+func (p *param) setFromEnvJoin(opts Options) error {
+	var vals, keys []string
+	for _, key := range p.envJoin {
+		if val, ok := opts.lookupEnv(key); ok {
+			vals = append(vals, val)
+			keys = append(keys, key)
+		}
+	}
+	if vals == nil {
+		return nil
+	}
+	return p.setParam(strings.Join(vals, p.joinSeparator), "environment variables", strings.Join(keys, ","))
+}
+
+// environ returns the environment configured in o in the format returned by
+// os.Environ.
+func (o Options) environ() []string {
+	if o.env == nil {
+		return os.Environ()
+	}
+	environ := make([]string, 0, len(o.env))
+	for k, v := range o.env {
+		environ = append(environ, k+"="+v)
+	}
+	return environ
+}
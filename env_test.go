@@ -0,0 +1,105 @@
+package configparser
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestEnvMap(t *testing.T) {
+	env := map[string]string{
+		"ENVMAPTEST_HOST":      "localhost",
+		"ENVMAPTEST_Port":      "8080",
+		"ENVMAPTEST_EMPTY":     "",
+		"ENVMAPTEST_":          "ignored",
+		"OTHER_ENVMAPTEST_KEY": "ignored",
+		"envmaptest_lower":     "ignored",
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+	}
+	defer func() {
+		for k := range env {
+			os.Unsetenv(k)
+		}
+	}()
+
+	expected := map[string]string{
+		"host":  "localhost",
+		"port":  "8080",
+		"empty": "",
+	}
+	if result := EnvMap("ENVMAPTEST_"); !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v but got %v instead", expected, result)
+	}
+}
+
+func TestLegacyEnvPrefix(t *testing.T) {
+	type Config struct {
+		Host string `default:"localhost"`
+		Port int    `env:"LISTEN_PORT"`
+	}
+
+	tables := []struct {
+		opts     Options
+		env      map[string]string
+		expected Config
+	}{
+		{Options{LegacyEnvPrefix: "OLD_"}, map[string]string{"OLD_HOST": "legacy", "OLD_LISTEN_PORT": "7000"}, Config{"legacy", 7000}},
+		{Options{LegacyEnvPrefix: "OLD_"}, map[string]string{"OLD_HOST": "legacy", "HOST": "new"}, Config{"new", 0}},
+		{Options{LegacyEnvPrefix: "OLD_", EnvPrefix: "MYAPP_"}, map[string]string{"OLD_HOST": "legacy", "HOST": "unprefixed"}, Config{"legacy", 0}},
+		{Options{}, map[string]string{"OLD_HOST": "legacy"}, Config{"localhost", 0}},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		pr := deterministicParser("", table.env, nil, table.opts)
+
+		result := Config{}
+		if err := pr.Parse(&result); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+}
+
+func TestEnvJoin(t *testing.T) {
+	type Config struct {
+		FullName string `envjoin:"FIRST,LAST" joinsep:" " default:"anonymous"`
+		Path     string `envjoin:"DIR,FILE" noenv:"true"`
+	}
+
+	tables := []struct {
+		env      map[string]string
+		args     []string
+		expected Config
+	}{
+		{map[string]string{"FIRST": "Ada", "LAST": "Lovelace"}, []string{}, Config{"Ada Lovelace", ""}},
+		{map[string]string{"LAST": "Lovelace", "DIR": "/tmp/", "FILE": "x"}, []string{}, Config{"Lovelace", "/tmp/x"}},
+		{map[string]string{}, []string{}, Config{"anonymous", ""}},
+		{map[string]string{"FIRST": "Ada", "LAST": "Lovelace", "FULLNAME": "Grace Hopper"}, []string{}, Config{"Grace Hopper", ""}},
+		{map[string]string{"FIRST": "Ada", "LAST": "Lovelace"}, []string{"-fullname", "Alan Turing"}, Config{"Alan Turing", ""}},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		result := Config{}
+		if err := ParseDeterministic(&result, "", table.env, table.args); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+
+	invalid := struct {
+		Name string `joinsep:" "`
+	}{}
+	if err := ParseDeterministic(&invalid, "", nil, nil); err == nil {
+		t.Error("Expected an error for a joinsep tag without an envjoin tag but did not get it")
+	}
+}
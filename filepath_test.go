@@ -0,0 +1,70 @@
+//go:build linux || darwin
+
+package configparser
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestFilePath(t *testing.T) {
+	dir, err := createFilesInTempDir(map[string]configFile{"token": {contents: "from-file"}})
+	if err != nil {
+		t.Fatalf("Could not create files in temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Could not create pipe: %v", err)
+	}
+	w.Write([]byte("from-fd"))
+	w.Close()
+	// Parse closes the file descriptor it reads, so give it a duplicate of
+	// the pipe's read end.
+	fd, err := syscall.Dup(int(r.Fd()))
+	r.Close()
+	if err != nil {
+		t.Fatalf("Could not duplicate file descriptor: %v", err)
+	}
+
+	// Struct tags are constant, so the struct has to be built at runtime to
+	// refer to the file descriptor.
+	configType := reflect.StructOf([]reflect.StructField{
+		{Name: "Token", Type: reflect.TypeOf(""), Tag: reflect.StructTag(fmt.Sprintf(`filepath:%q`, filepath.Join(dir, "token")))},
+		{Name: "Credential", Type: reflect.TypeOf(""), Tag: reflect.StructTag(fmt.Sprintf(`filepath:"fd:%d"`, fd))},
+		{Name: "Missing", Type: reflect.TypeOf(""), Tag: reflect.StructTag(fmt.Sprintf(`filepath:%q default:"fallback"`, filepath.Join(dir, "missing")))},
+	})
+	config := reflect.New(configType)
+
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	if err := Parse(config.Interface()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if token := config.Elem().Field(0).String(); token != "from-file" {
+		t.Errorf("Expected token from-file but got %q instead", token)
+	}
+	if credential := config.Elem().Field(1).String(); credential != "from-fd" {
+		t.Errorf("Expected credential from-fd but got %q instead", credential)
+	}
+	if missing := config.Elem().Field(2).String(); missing != "fallback" {
+		t.Errorf("Expected missing to fall back to its default but got %q instead", missing)
+	}
+
+	// The file descriptor has been closed, so reading it again fails.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	if err := Parse(reflect.New(configType).Interface()); err == nil {
+		t.Error("Expected an error for a closed file descriptor but did not get it")
+	} else {
+		t.Logf("Expected an error - got: %v", err)
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
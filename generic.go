@@ -0,0 +1,10 @@
+package configparser
+
+// ParseInto allocates a T, which must be a struct type, populates it as
+// ParseWithDir would with the given dir, and returns it. It saves callers from
+// declaring a variable and passing a pointer to it.
+func ParseInto[T any](dir string) (T, error) {
+	var config T
+	err := ParseWithDir(&config, dir)
+	return config, err
+}
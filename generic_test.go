@@ -0,0 +1,33 @@
+package configparser
+
+import (
+	"flag"
+	"os"
+	"testing"
+)
+
+func TestParseInto(t *testing.T) {
+	type Config struct {
+		Hostname string `default:"localhost"`
+		Port     int    `default:"8080"`
+	}
+
+	setFlags([]string{"-port", "9090"})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	config, err := ParseInto[Config]("")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := (Config{"localhost", 9090}); config != expected {
+		t.Errorf("Expected %+v but got %+v instead", expected, config)
+	}
+
+	// A type which isn't a struct results in an error.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	if _, err := ParseInto[int](""); err == nil {
+		t.Error("Expected an error for a non-struct type but did not get it")
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
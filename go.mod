@@ -1,3 +1,3 @@
 module github.com/kwkoo/configparser
 
-go 1.12
+go 1.18
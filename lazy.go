@@ -0,0 +1,44 @@
+package configparser
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+	"unsafe"
+)
+
+var lazySecretType = reflect.TypeOf((func() (string, error))(nil))
+
+// setLazySecret sets a field of type func() (string, error) to a function
+// which looks up the field's value each time it is called, so that the value
+// is only held in memory while the caller needs it. The field counts as set,
+// since whether it has a value is only known when the function is called.
+func (p *param) setLazySecret(configFiles map[string]string, dir string, opts Options) {
+	// Options.Timeout only bounds the parse itself.
+	opts.deadline = time.Time{}
+	sp := *p
+	fn := func() (string, error) {
+		var val string
+		lp := sp
+		lp.fieldKind = reflect.String
+		lp.fieldType = reflect.TypeOf(val)
+		lp.paramPointer = unsafe.Pointer(&val)
+		sources := lookupSources
+		if lp.sources != nil {
+			sources = lp.sources
+		}
+		found, err := resolveSources(&lp, sources, configFiles, dir, opts)
+		if err != nil {
+			return "", err
+		}
+		if found {
+			return val, nil
+		}
+		if lp.hasDefault {
+			return lp.defaultValue, nil
+		}
+		return "", fmt.Errorf("secret field %s is not set in any of its sources", lp.name)
+	}
+	reflect.NewAt(p.fieldType, p.paramPointer).Elem().Set(reflect.ValueOf(fn))
+	p.isSet = true
+}
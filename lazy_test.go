@@ -0,0 +1,78 @@
+package configparser
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLazySecret(t *testing.T) {
+	dir, err := createFilesInTempDir(map[string]configFile{"password": {contents: "initial"}})
+	if err != nil {
+		t.Fatalf("Could not create files in temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := struct {
+		Password func() (string, error) `lazysecret:"true"`
+		APIKey   func() (string, error) `lazysecret:"true" env:"LAZY_API_KEY"`
+		Token    func() (string, error) `lazysecret:"true" default:"none"`
+		Missing  func() (string, error) `lazysecret:"true"`
+	}{}
+
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	if err := ParseWithDir(&config, dir); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The values are only read when the functions are called.
+	os.Setenv("LAZY_API_KEY", "key")
+	defer os.Unsetenv("LAZY_API_KEY")
+	if err := os.WriteFile(filepath.Join(dir, "password"), []byte("rotated"), 0644); err != nil {
+		t.Fatalf("Could not update file: %v", err)
+	}
+
+	tables := []struct {
+		name     string
+		fn       func() (string, error)
+		expected string
+		isErr    bool
+	}{
+		{"Password", config.Password, "rotated", false},
+		{"APIKey", config.APIKey, "key", false},
+		{"Token", config.Token, "none", false},
+		{"Missing", config.Missing, "", true},
+	}
+	for _, table := range tables {
+		if table.fn == nil {
+			t.Errorf("Expected %s to be set but it is nil", table.name)
+			continue
+		}
+		val, err := table.fn()
+		if table.isErr {
+			if err == nil {
+				t.Errorf("Expected an error for %s but did not get it", table.name)
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", table.name, err)
+			continue
+		}
+		if val != table.expected {
+			t.Errorf("Expected %s to be %q but got %q instead", table.name, table.expected, val)
+		}
+	}
+
+	// Lazy secrets have no command line flag.
+	if flag.Lookup("password") != nil {
+		t.Error("Expected no command line flag for a lazy secret")
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
@@ -0,0 +1,42 @@
+package configparser
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// logLevels are the values allowed in string fields with a loglevel tag.
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+// levelTypes maps the log level types from other packages which are supported
+// natively, such as slog.Level, to a function which parses a level name.
+var levelTypes = map[reflect.Type]func(string) (interface{}, error){}
+
+// validLogLevels lists the level names for error messages.
+func validLogLevels() string {
+	return strings.Join(logLevels, ", ")
+}
+
+// logLevel returns the lowercase level name for val, which is matched without
+// regard to case.
+func logLevel(val string) (string, error) {
+	level := strings.ToLower(strings.TrimSpace(val))
+	for _, l := range logLevels {
+		if level == l {
+			return level, nil
+		}
+	}
+	return "", fmt.Errorf("must be one of %s", validLogLevels())
+}
+
+// setLevel sets the field, which must be one of levelTypes, to the level named
+// by val.
+func (p *param) setLevel(val, configType, keyName string) error {
+	level, err := levelTypes[p.fieldType](strings.TrimSpace(val))
+	if err != nil {
+		return fmt.Errorf("%s %s for field %s must be one of %s - instead it is: %v", configType, keyName, p.name, validLogLevels(), val)
+	}
+	reflect.NewAt(p.fieldType, p.paramPointer).Elem().Set(reflect.ValueOf(level))
+	return nil
+}
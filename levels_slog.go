@@ -0,0 +1,16 @@
+//go:build go1.21
+
+package configparser
+
+import (
+	"log/slog"
+	"reflect"
+)
+
+func init() {
+	levelTypes[reflect.TypeOf(slog.Level(0))] = func(val string) (interface{}, error) {
+		var level slog.Level
+		err := level.UnmarshalText([]byte(val))
+		return level, err
+	}
+}
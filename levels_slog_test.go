@@ -0,0 +1,67 @@
+//go:build go1.21
+
+package configparser
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSlogLevels(t *testing.T) {
+	type Config struct {
+		Level slog.Level `env:"LEVEL" default:"info"`
+	}
+
+	tables := []struct {
+		env      string
+		expected slog.Level
+		isErr    bool
+	}{
+		{"", slog.LevelInfo, false},
+		{"warn", slog.LevelWarn, false},
+		{"DEBUG", slog.LevelDebug, false},
+		{"error+2", slog.LevelError + 2, false},
+		{"verbose", 0, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags([]string{})
+		if table.env == "" {
+			os.Unsetenv("LEVEL")
+		} else {
+			os.Setenv("LEVEL", table.env)
+		}
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		result := Config{}
+		err := Parse(&result)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else if !strings.Contains(err.Error(), validLogLevels()) {
+				t.Errorf("Expected the error to list the valid levels but got: %v", err)
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result.Level != table.expected {
+			t.Errorf("Expected %v but got %v instead", table.expected, result.Level)
+		}
+	}
+
+	os.Unsetenv("LEVEL")
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
@@ -0,0 +1,73 @@
+package configparser
+
+import (
+	"flag"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestLogLevels(t *testing.T) {
+	type Config struct {
+		Level   string   `env:"LEVEL" loglevel:"true" default:"info"`
+		Modules []string `env:"MODULE_LEVELS" loglevel:"true"`
+	}
+
+	tables := []struct {
+		env      map[string]string
+		expected Config
+		isErr    bool
+	}{
+		{map[string]string{}, Config{"info", nil}, false},
+		{map[string]string{"LEVEL": "WARN"}, Config{"warn", nil}, false},
+		{map[string]string{"LEVEL": "debug", "MODULE_LEVELS": "error,Info"}, Config{"debug", []string{"error", "info"}}, false},
+		{map[string]string{"LEVEL": "verbose"}, Config{}, true},
+		{map[string]string{"MODULE_LEVELS": "info,trace"}, Config{}, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags([]string{})
+		for _, key := range []string{"LEVEL", "MODULE_LEVELS"} {
+			if val, ok := table.env[key]; ok {
+				os.Setenv(key, val)
+			} else {
+				os.Unsetenv(key)
+			}
+		}
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		result := Config{}
+		err := Parse(&result)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if !reflect.DeepEqual(result, table.expected) {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+
+	os.Unsetenv("LEVEL")
+	os.Unsetenv("MODULE_LEVELS")
+
+	invalid := struct {
+		Level int `loglevel:"true"`
+	}{}
+	if err := Parse(&invalid); err == nil {
+		t.Error("Expected an error for a loglevel tag on an int field but did not get it")
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
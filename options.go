@@ -0,0 +1,393 @@
+package configparser
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"runtime/debug"
+	"time"
+)
+
+// Options customizes the behavior of ParseWithOptions. The zero value gives
+// the same behavior as ParseWithDir.
+type Options struct {
+	// EnvPrefix is prepended to the name of every environment variable,
+	// including names specified with the env and envindexed tags. For
+	// example, with an EnvPrefix of "MYAPP_", the Port field is set from
+	// MYAPP_PORT.
+	EnvPrefix string
+
+	// LegacyEnvPrefix, if not empty, gives every field a second environment
+	// variable which is used if the field's usual one is not set, named with
+	// LegacyEnvPrefix instead of EnvPrefix. For example, with a
+	// LegacyEnvPrefix of "OLD_", the Host field is set from HOST, or from
+	// OLD_HOST if HOST is not set. This eases renaming an application's
+	// environment variables. The usual variable always wins if both are set.
+	// Variables named with the envindexed tag have no legacy name.
+	LegacyEnvPrefix string
+
+	// StripInlineComments removes a trailing comment, starting with #, from
+	// the first line of each file's contents before the value is parsed, so
+	// a file containing "8080 # port" is read as "8080". This is off by
+	// default because # may be a legitimate part of a value.
+	StripInlineComments bool
+
+	// Metrics, if not nil, is filled in with timings and counts collected
+	// while parsing. Nothing is measured if Metrics is nil.
+	Metrics *ParseMetrics
+
+	// Result, if not nil, is filled in with information about how the
+	// struct was parsed.
+	Result *ParseResult
+
+	// Logger is used to log warnings, such as skipped fields and deprecated
+	// fields being set. If Logger is nil, the standard logger is used.
+	Logger *log.Logger
+
+	// DefaultsDir, if not empty, is a directory of fallback files for
+	// fields which aren't set from any other source, so that factory
+	// defaults can be shipped as files. Each file is named after the field's
+	// config file key, as in the config directory. A file in DefaultsDir
+	// takes precedence only over the field's default tag, and a missing file
+	// leaves the default alone. A relative DefaultsDir is relative to the
+	// directory containing the executable, e.g. "defaults".
+	DefaultsDir string
+
+	// OnConflict, if not nil, is called when a field is set from a file and
+	// its environment variable is also set to a different value. The file
+	// still takes precedence - OnConflict is only used to report the
+	// environment variable being ignored.
+	OnConflict func(field, fileVal, envVal string)
+
+	// ErrorOnNoFields makes ParseWithOptions return ErrNoFields if the struct
+	// has no fields which can be parsed, e.g. because every field is of an
+	// unsupported type. By default such a struct is silently accepted.
+	ErrorOnNoFields bool
+
+	// StrictTypes makes ParseWithOptions return an error for a field which
+	// is not of a supported type, instead of logging that it is skipped.
+	StrictTypes bool
+
+	// OnUnknownField, if not nil, is called for each field which is not of a
+	// supported type when StrictTypes is set. Returning nil skips the field,
+	// and returning an error aborts parsing with that error.
+	OnUnknownField func(fieldName string, kind reflect.Kind) error
+
+	// UnknownFlagsAsWarnings makes ParseWithOptions log a warning for each
+	// command line flag which doesn't correspond to a field, and carry on
+	// with the arguments which follow it. An unknown flag is assumed not to
+	// take a separate value. By default an unknown flag results in an error
+	// which names it. Either way, unknown flags can only be handled if
+	// flag.CommandLine uses flag.ContinueOnError.
+	UnknownFlagsAsWarnings bool
+
+	// SpaceSeparatedBoolFlags makes a bool command line flag which is
+	// followed by true or false take that argument as its value, so that
+	// -async false is the same as -async=false. By default, as with the flag
+	// package, the following argument is left alone and -async on its own
+	// sets the field to true.
+	SpaceSeparatedBoolFlags bool
+
+	// GenerateNegatedBoolFlags registers a second command line flag for
+	// each bool field, named after the field's flag with a no- prefix, which
+	// sets the field to false, e.g. -no-async. Using both forms of a flag on
+	// the same command line is an error.
+	GenerateNegatedBoolFlags bool
+
+	// BlankFileAsUnset makes a file which is empty or only contains
+	// whitespace count as not being there, so the field's other sources and
+	// its default are consulted instead. This suits placeholder files
+	// created by provisioning tools. By default the contents of a blank
+	// file are used as the field's value.
+	BlankFileAsUnset bool
+
+	// TrimFileWhitespace removes all trailing whitespace, including several
+	// newlines, from each file's contents before the value is parsed. By
+	// default only a single trailing newline is removed. Neither applies to
+	// fields with an encoding tag, or to the files matched by a file tag
+	// which is a pattern, whose contents are used as they are.
+	TrimFileWhitespace bool
+
+	// ResolveDirRelativeToExe makes a relative config directory relative to
+	// the directory containing the running executable, as reported by
+	// os.Executable, instead of the current working directory. This applies
+	// to the directory from every source, including the environment variable
+	// and command line flag configured with Parser.WithConfigDirectory, and
+	// lets a self-contained tool find its bundled config wherever it is
+	// launched from.
+	ResolveDirRelativeToExe bool
+
+	// DebugMultipleSources makes ParseWithOptions log each field which was
+	// set from more than one source, such as a command line flag which was
+	// then overridden by a file, listing the sources in the order they were
+	// applied. Default values are not counted. The documented precedence
+	// still decides which value is used - this is a development aid for
+	// spotting fields which are resolved more often than expected.
+	DebugMultipleSources bool
+
+	// OnComplete, if not nil, is called once at the end of every parse with
+	// the error which is about to be returned, or nil if parsing succeeded.
+	// This gives a single place to log the outcome of loading the config.
+	OnComplete func(err error)
+
+	// FileEnvVars makes a field whose environment variable is not set fall
+	// back to the variable of the same name with _FILE appended, which holds
+	// the path of a file containing the field's value, e.g. PASSWORD_FILE
+	// for a field whose environment variable is PASSWORD. This is a common
+	// convention for passing secrets to containers. The file must exist if
+	// the _FILE variable is set. It is an error for a field to be set from a
+	// file in the config directory, or from its relfile or filepath, if its
+	// _FILE variable also names a file which exists.
+	FileEnvVars bool
+
+	// FileEnvConflictAsWarning makes a field which is set from a file while
+	// its _FILE environment variable names another file log a warning
+	// instead of returning an error. The file which would be used without
+	// FileEnvVars takes precedence. See FileEnvVars.
+	FileEnvConflictAsWarning bool
+
+	// KeyringGetter, if not nil, reads an entry from the OS keyring for
+	// fields with a keyring tag. It should return an error wrapping
+	// ErrKeyringNotFound if there is no entry for service and account, in
+	// which case the field falls through to its command line flag or
+	// default. Any other error is returned by ParseWithOptions. Fields with a
+	// keyring tag are not read from the keyring if KeyringGetter is nil. This
+	// keeps platform-specific keyring code out of this package.
+	KeyringGetter func(service, account string) (string, error)
+
+	// ErrorOnUnsetDefaultVars makes a default tag which refers to an unset
+	// environment variable as ${VAR}, without a fallback, result in an
+	// error. By default the variable is replaced with an empty string.
+	ErrorOnUnsetDefaultVars bool
+
+	// EnumMaps maps the names of int fields, or slices of ints, to the names
+	// which may be used for their values, e.g.
+	//
+	//	EnumMaps: map[string]map[string]int{
+	//		"Level": {"debug": int(Debug), "info": int(Info)},
+	//	}
+	//
+	// lets the Level field, of a type Level int, be set with LEVEL=info.
+	// Names are matched without regard to ASCII case if there is no exact
+	// match, and a value which isn't one of the names is an error. The
+	// field's value is formatted using its name, e.g. in the usage text.
+	EnumMaps map[string]map[string]int
+
+	// Timeout, if not zero, bounds the time spent walking the config
+	// directory and reading files. If it is exceeded, ParseWithOptions
+	// returns an error wrapping ErrTimeout. A file operation which is still
+	// blocked when the timeout expires is abandoned rather than interrupted.
+	Timeout time.Duration
+
+	// TimeLayouts maps names to time layouts, e.g.
+	//
+	//	TimeLayouts: map[string]string{"iso": "2006-01-02"},
+	//
+	// which time.Time fields can refer to with a layoutname tag, e.g.
+	// layoutname:"iso", instead of repeating the layout in a layout tag.
+	// A layoutname tag which isn't in TimeLayouts is an error.
+	TimeLayouts map[string]string
+
+	// EnvSuffix, if not empty, makes a field's file with EnvSuffix appended
+	// after a dot take precedence over the file itself, so that with an
+	// EnvSuffix of "production", the Port field is read from port.production
+	// if it exists and from port otherwise. This allows per-environment
+	// overrides to live alongside the shared files in one directory, e.g.
+	// with EnvSuffix set from an APP_ENV environment variable. Each file in a
+	// file tag listing several candidates is tried with the suffix first.
+	// File tags which are patterns are not affected.
+	EnvSuffix string
+
+	// HTTPClient is used by ParseFromURL to fetch the document. If
+	// HTTPClient is nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// HTTPTimeout limits how long ParseFromURL waits for the document,
+	// including reading the response body. Zero means no limit other than
+	// any timeout set on HTTPClient.
+	HTTPTimeout time.Duration
+
+	// ValueTransform, if not nil, is called with the field's name and each
+	// value read for it from a config source, before the value is
+	// converted to the field's type, and the value it returns is used
+	// instead. This is a single place to unwrap or decrypt values which are
+	// stored in a special form. Default values are not passed to it, as
+	// they are written in the code. An error from ValueTransform stops
+	// parsing.
+	ValueTransform func(field, raw string) (string, error)
+
+	// Decryptor decrypts the values of fields with an encrypted tag. It is
+	// called with each value read for such a field from a config source,
+	// after ValueTransform, and the plaintext it returns is used instead. An
+	// error from Decryptor stops parsing. It must be set if any field has an
+	// encrypted tag. This keeps cryptography out of this package.
+	Decryptor func(ciphertext string) (string, error)
+
+	// PromptForMissing makes a mandatory field which was not set from any of
+	// its sources be prompted for on the terminal, with the input not
+	// echoed so that secrets can be typed in safely. Fields are prompted
+	// for in the order they are declared, by name. A field left empty is
+	// reported as missing as usual. If standard input is not a terminal,
+	// nothing is prompted for. Terminals are currently only detected on
+	// Linux.
+	PromptForMissing bool
+
+	converters map[reflect.Type]Converter
+
+	// env, args and flagSet, if not nil, are used instead of the process
+	// environment, os.Args[1:] and flag.CommandLine. See ParseDeterministic.
+	env     map[string]string
+	args    []string
+	flagSet *flag.FlagSet
+
+	// terminal, if not nil, is used instead of standard input to prompt for
+	// missing fields. See PromptForMissing.
+	terminal terminal
+
+	// readBuildInfo, if not nil, is used instead of debug.ReadBuildInfo for
+	// the buildinfo tag.
+	readBuildInfo func() (*debug.BuildInfo, bool)
+
+	// fsys, if not nil, holds the config files instead of the config
+	// directory. See Parser.WithFS.
+	fsys fs.FS
+
+	// deadline is when Timeout expires during the current parse.
+	deadline time.Time
+
+	// dirIndexes maps the path of each config file to the index of its
+	// directory when the parser has several directories. See
+	// Parser.WithDirs.
+	dirIndexes map[string]int
+}
+
+// Converter converts a raw config value into a value which can be assigned to
+// a field of the type the converter is registered for.
+type Converter func(string) (interface{}, error)
+
+// RegisterConverter registers fn as the converter for fields of type t, so
+// that ParseWithOptions can set fields of types it doesn't natively support.
+// The converter is also used for the elements of slices of t. Converters are
+// not consulted for natively supported types.
+func (o *Options) RegisterConverter(t reflect.Type, fn Converter) {
+	if o.converters == nil {
+		o.converters = make(map[reflect.Type]Converter)
+	}
+	o.converters[t] = fn
+}
+
+// commandLine returns the flag set which the struct's flags are registered
+// with.
+func (o Options) commandLine() *flag.FlagSet {
+	if o.flagSet != nil {
+		return o.flagSet
+	}
+	return flag.CommandLine
+}
+
+// commandArgs returns the command line arguments, without the program name.
+func (o Options) commandArgs() []string {
+	if o.args != nil {
+		return o.args
+	}
+	return os.Args[1:]
+}
+
+// usage prints the usage message for the flag set returned by commandLine.
+func (o Options) usage() {
+	fs := o.commandLine()
+	switch {
+	case fs == flag.CommandLine:
+		flag.Usage()
+	case fs.Usage != nil:
+		fs.Usage()
+	case fs.Name() == "":
+		fmt.Fprintln(fs.Output(), "Usage:")
+		fs.PrintDefaults()
+	default:
+		fmt.Fprintf(fs.Output(), "Usage of %s:\n", fs.Name())
+		fs.PrintDefaults()
+	}
+}
+
+// ErrKeyringNotFound is returned by Options.KeyringGetter for an entry which
+// doesn't exist.
+var ErrKeyringNotFound = errors.New("keyring entry not found")
+
+// ErrTimeout is wrapped by the error returned when Options.Timeout expires.
+var ErrTimeout = errors.New("timed out reading config files")
+
+// bounded runs op, giving up if the deadline set from Timeout passes first.
+func (o Options) bounded(op func() error) error {
+	if o.deadline.IsZero() {
+		return op()
+	}
+	remaining := time.Until(o.deadline)
+	if remaining <= 0 {
+		return fmt.Errorf("config files were not read within %v: %w", o.Timeout, ErrTimeout)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- op()
+	}()
+	timer := time.NewTimer(remaining)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("config files were not read within %v: %w", o.Timeout, ErrTimeout)
+	}
+}
+
+func (o Options) logf(format string, v ...interface{}) {
+	if o.Logger == nil {
+		log.Printf(format, v...)
+		return
+	}
+	o.Logger.Printf(format, v...)
+}
+
+// ParseResult holds information about how ParseWithOptions parsed a struct.
+type ParseResult struct {
+	// Dir is the config directory which was used, after taking into account
+	// the environment variable and command line flag configured with
+	// Parser.WithConfigDirectory. It is empty if no config directory was
+	// used. Dir is filled in even if walking the directory fails.
+	Dir string
+
+	// DirExists is true if Dir exists and is a directory.
+	DirExists bool
+
+	// FieldDirs maps the name of each field whose value came from a file in
+	// one of the directories given to ParseWithDirs or Parser.WithDirs to
+	// the index of that directory. Fields which were set from another
+	// source, or which were overridden by one, are left out. It is nil if
+	// the parser doesn't have several directories.
+	FieldDirs map[string]int
+}
+
+// ParseMetrics holds timings and counts collected by ParseWithOptions.
+type ParseMetrics struct {
+	// DirScan is the time taken to walk the config directory.
+	DirScan time.Duration
+
+	// FlagParse is the time taken to parse the command line flags.
+	FlagParse time.Duration
+
+	// SourceLookup is the time taken to read files and look up environment
+	// variables.
+	SourceLookup time.Duration
+
+	// FilesScanned is the number of files found in the config directory.
+	FilesScanned int
+
+	// FieldsResolved is the number of fields which were set from any source,
+	// including default values.
+	FieldsResolved int
+}
@@ -1,38 +1,405 @@
 package configparser
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/fs"
-	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 	"unsafe"
 )
 
-var params []*param
+// ErrNoFields is returned by ParseWithOptions when Options.ErrorOnNoFields is
+// set and the struct has no fields which can be parsed.
+var ErrNoFields = errors.New("struct has no fields which can be parsed")
+
+var durationType = reflect.TypeOf(time.Duration(0))
+var timeType = reflect.TypeOf(time.Time{})
+var fileModeType = reflect.TypeOf(os.FileMode(0))
+var regexpType = reflect.TypeOf((*regexp.Regexp)(nil))
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// executable returns the path of the running executable. It is a variable so
+// that tests can stub it.
+var executable = os.Executable
 
 type param struct {
-	filename     string
-	envKey       string
-	flagKey      string
-	fieldKind    reflect.Kind
-	paramPointer unsafe.Pointer
-	mandatory    bool
-	isSet        bool
+	name             string
+	filename         string
+	relFile          string
+	filePath         string
+	envKey           string
+	legacyEnvKey     string
+	envJoin          []string
+	buildInfo        string
+	joinSeparator    string
+	keyringService   string
+	keyringAccount   string
+	flagKey          string
+	fieldKind        reflect.Kind
+	fieldType        reflect.Type
+	paramPointer     unsafe.Pointer
+	fieldPointer     unsafe.Pointer
+	allocated        bool
+	mandatory        bool
+	mandatoryIf      string
+	fileExists       bool
+	extendedDuration bool
+	clockDuration    bool
+	layout           string
+	unixTime         string
+	base             int
+	unmarshalJSON    bool
+	converter        Converter
+	converters       map[reflect.Type]Converter
+	valueTransform   func(field, raw string) (string, error)
+	requirePrefix    string
+	encrypted        bool
+	decryptor        func(ciphertext string) (string, error)
+	format           string
+	strip            string
+	decimalComma     bool
+	unit             string
+	logLevel         bool
+	count            bool
+	enum             map[string]int
+	encodings        []string
+	deprecated       string
+	separator        string
+	fileSeparator    string
+	envSeparator     string
+	envIndexed       string
+	structElems      bool
+	jsonStruct       bool
+	lazySecret       bool
+	secret           bool
+	multipleOf       int
+	min              string
+	max              string
+	minVal           float64
+	maxVal           float64
+	oneOf            []string
+	pattern          *regexp.Regexp
+	minItems         int
+	maxItems         int
+	hasMinItems      bool
+	hasMaxItems      bool
+	checksumAlgo     string
+	checksumField    string
+	equalTo          string
+	pathMustExist    bool
+	pathReadable     bool
+	group            string
+	groupPolicy      string
+	isSet            bool
+
+	// sources is the order in which the field's sources are consulted if it
+	// has a sources tag, and nil otherwise. The values of the sources which
+	// are set before the field is resolved are kept so that they can be
+	// applied in that order.
+	sources       []string
+	flagValue     string
+	flagSeen      bool
+	negatedSeen   bool
+	documentKey   string
+	documentValue string
+	hasDocument   bool
+	defaultValue  string
+	hasDefault    bool
+
+	// source and sourceKey record where the field's current value came from,
+	// e.g. "environment variable" and "HOST".
+	source    string
+	sourceKey string
+
+	// dirIndex is the index of the config directory containing the file the
+	// field was last set from, if hasDirIndex is true. See Parser.WithDirs.
+	dirIndex    int
+	hasDirIndex bool
+
+	// assignedFrom lists the sources, other than the default value, which
+	// the field has been set from during the current parse, in order. It is
+	// reported by Options.DebugMultipleSources.
+	assignedFrom []string
+}
+
+// isSupportedType returns true if ParseWithDir knows how to set a field of
+// type t, either natively or with one of converters.
+func isSupportedType(t reflect.Type, converters map[reflect.Type]Converter) bool {
+	if converters[t] != nil {
+		return true
+	}
+	if t.Kind() == reflect.Slice && !implementsJSONUnmarshaler(t) {
+		// A []byte is not treated as a list of numbers.
+		if t.Elem().Kind() == reflect.Uint8 {
+			return converters[t.Elem()] != nil
+		}
+		return isSupportedScalarType(t.Elem()) || converters[t.Elem()] != nil
+	}
+	if t.Kind() == reflect.Map && !implementsJSONUnmarshaler(t) {
+		return (isSupportedScalarType(t.Key()) || converters[t.Key()] != nil) &&
+			(isSetType(t) || isSupportedScalarType(t.Elem()) || converters[t.Elem()] != nil)
+	}
+	return isSupportedScalarType(t) || isPointerType(t)
+}
+
+// converterFor returns the converter in converters for t, or nil if t is
+// natively supported or has no converter.
+func converterFor(t reflect.Type, converters map[reflect.Type]Converter) Converter {
+	if isSupportedType(t, nil) {
+		return nil
+	}
+	return converters[t]
+}
+
+// isSupportedScalarType returns true if ParseWithDir knows how to set a field
+// of type t, or a slice element of type t, from a single value.
+func isSupportedScalarType(t reflect.Type) bool {
+	if t == durationType || t == timeType || t == fileModeType || t == regexpType || implementsJSONUnmarshaler(t) {
+		return true
+	}
+	if isByteArrayType(t) {
+		return true
+	}
+	k := t.Kind()
+	return k == reflect.String || k == reflect.Int || k == reflect.Bool || k == reflect.Float64 || k == reflect.Float32 || isSizedIntKind(k) || isUintKind(k)
+}
+
+// isSizedIntKind returns true if k is one of the signed integer kinds other
+// than int, which has extra support, e.g. for enum names and units.
+func isSizedIntKind(k reflect.Kind) bool {
+	return k == reflect.Int8 || k == reflect.Int16 || k == reflect.Int32 || k == reflect.Int64
+}
+
+// isUintKind returns true if k is one of the unsigned integer kinds, not
+// including uintptr.
+func isUintKind(k reflect.Kind) bool {
+	return k == reflect.Uint || k == reflect.Uint8 || k == reflect.Uint16 || k == reflect.Uint32 || k == reflect.Uint64
+}
+
+// isPointerType returns true if t is a pointer to a string, int or bool,
+// which ParseWithDir leaves nil unless the field is set.
+func isPointerType(t reflect.Type) bool {
+	if t.Kind() != reflect.Ptr {
+		return false
+	}
+	k := t.Elem().Kind()
+	return k == reflect.String || k == reflect.Int || k == reflect.Bool
+}
+
+// fieldPointers returns the addresses used by a param for field. For a
+// pointer field, paramPointer is the address the field points to, or nil if
+// it is nil, and fieldPointer is the address of the field itself.
+func fieldPointers(field reflect.Value) (paramPointer, fieldPointer unsafe.Pointer) {
+	if !isPointerType(field.Type()) {
+		return unsafe.Pointer(field.Addr().Pointer()), nil
+	}
+	if !field.IsNil() {
+		paramPointer = unsafe.Pointer(field.Pointer())
+	}
+	return paramPointer, unsafe.Pointer(field.Addr().Pointer())
+}
+
+// allocate points a pointer field at a new value the first time it is set,
+// so that whatever it pointed to before is left alone.
+func (p *param) allocate() {
+	if p.fieldPointer == nil || p.allocated {
+		return
+	}
+	v := reflect.New(p.fieldType)
+	reflect.NewAt(reflect.PtrTo(p.fieldType), p.fieldPointer).Elem().Set(v)
+	p.paramPointer = unsafe.Pointer(v.Pointer())
+	p.allocated = true
+}
+
+// isByteArrayType returns true if t is an array of bytes, such as [32]byte.
+func isByteArrayType(t reflect.Type) bool {
+	return t.Kind() == reflect.Array && t.Elem().Kind() == reflect.Uint8
+}
+
+// MissingMandatoryError is returned by ParseWithDir when mandatory fields
+// were not set from any of their sources. All the other fields have been
+// resolved, so the struct holds best-effort values which can be used for
+// diagnostics, e.g. to show the partial config.
+type MissingMandatoryError struct {
+	// Fields lists the names of the missing fields, in the order they are
+	// declared.
+	Fields []string
+}
+
+func (e *MissingMandatoryError) Error() string {
+	return fmt.Sprintf("%d mandatory parameters missing", len(e.Fields))
+}
+
+// isMissing reports whether the param is mandatory, either always or because
+// the field named in its mandatoryif tag is set, but was not set from any of
+// its sources. byName maps field names to their params.
+func (p param) isMissing(byName map[string]*param) bool {
+	mandatory := p.mandatory || (p.mandatoryIf != "" && !byName[p.mandatoryIf].isZero())
+	return mandatory && !p.isSet
+}
+
+// mandatoryMessage returns the message printed when the param is mandatory
+// but was not set from any of its sources.
+func (p param) mandatoryMessage() string {
+	if p.flagKey != "" && p.envKey != "" {
+		return fmt.Sprintf("Mandatory flag -%s (or environment variable %s) does not exist.", p.flagKey, p.envKey)
+	}
+	if p.flagKey != "" {
+		return fmt.Sprintf("Mandatory flag -%s does not exist.", p.flagKey)
+	}
+	if p.envKey != "" {
+		return fmt.Sprintf("Mandatory environment variable %s does not exist.", p.envKey)
+	}
+	if p.structElems {
+		return fmt.Sprintf("Mandatory environment variables %s_0_* do not exist.", p.envIndexed)
+	}
+	if p.filename == "" && p.relFile == "" {
+		return fmt.Sprintf("Mandatory file %s does not exist.", p.filePath)
+	}
+	if p.filename == "" {
+		return fmt.Sprintf("Mandatory file %s does not exist.", p.relFile)
+	}
+	return fmt.Sprintf("Mandatory file %s does not exist.", strings.Join(p.fileCandidates(), " or "))
+}
+
+// implementsJSONUnmarshaler returns true if a pointer to a value of type t
+// implements json.Unmarshaler. time.Time is excluded because it is handled
+// natively.
+func implementsJSONUnmarshaler(t reflect.Type) bool {
+	return t != timeType && reflect.PtrTo(t).Implements(jsonUnmarshalerType)
 }
 
+// redacted is returned by String instead of the value of a field with a
+// secret tag.
+const redacted = "****"
+
+// String returns the field's value in the format it would be read from a
+// config source, or redacted if the field has a secret tag and isn't empty,
+// so that secrets don't appear in the usage text. Use value for the actual
+// value.
 func (p param) String() string {
+	// The flag package calls String on a zero param to find out whether a
+	// flag's default is its zero value.
+	if p.fieldType == nil {
+		return ""
+	}
+	if p.secret {
+		if p.isZero() {
+			return ""
+		}
+		return redacted
+	}
+	return p.value()
+}
+
+// value returns the field's value in the format it would be read from a
+// config source, or an empty string for a nil pointer field.
+func (p param) value() string {
+	if p.paramPointer == nil {
+		return ""
+	}
+	if p.converter != nil {
+		return fmt.Sprint(reflect.NewAt(p.fieldType, p.paramPointer).Elem().Interface())
+	}
+	if p.jsonStruct {
+		b, err := json.Marshal(reflect.NewAt(p.fieldType, p.paramPointer).Elem().Interface())
+		if err != nil {
+			return ""
+		}
+		return string(b)
+	}
+	if p.unmarshalJSON {
+		b, err := json.Marshal(reflect.NewAt(p.fieldType, p.paramPointer).Elem().Interface())
+		if err != nil {
+			return ""
+		}
+		var s string
+		if err := json.Unmarshal(b, &s); err == nil {
+			return s
+		}
+		return string(b)
+	}
+	if p.isSlice() {
+		slice := reflect.NewAt(p.fieldType, p.paramPointer).Elem()
+		elems := make([]string, slice.Len())
+		for i := range elems {
+			elems[i] = p.elemParam(slice.Index(i)).value()
+		}
+		return strings.Join(elems, p.separator)
+	}
+	if p.isMap() {
+		return p.entriesString()
+	}
+	if p.fieldType == durationType {
+		return (*((*time.Duration)(p.paramPointer))).String()
+	}
+	if p.fieldType == timeType {
+		t := *((*time.Time)(p.paramPointer))
+		if t.IsZero() {
+			return ""
+		}
+		switch p.unixTime {
+		case "s":
+			return strconv.FormatInt(t.Unix(), 10)
+		case "ms":
+			return strconv.FormatInt(t.UnixMilli(), 10)
+		case "us":
+			return strconv.FormatInt(t.UnixMicro(), 10)
+		case "ns":
+			return strconv.FormatInt(t.UnixNano(), 10)
+		}
+		return t.Format(p.layout)
+	}
+	if isByteArrayType(p.fieldType) {
+		array := reflect.NewAt(p.fieldType, p.paramPointer).Elem()
+		return encodeBytes(array.Slice(0, array.Len()).Bytes(), p.byteArrayEncoding())
+	}
+	if p.fieldType == regexpType {
+		if re := *((**regexp.Regexp)(p.paramPointer)); re != nil {
+			return re.String()
+		}
+		return ""
+	}
+	if p.fieldType == fileModeType {
+		mode := uint64(*((*os.FileMode)(p.paramPointer)))
+		if p.base == 0 || p.base == 8 {
+			return "0" + strconv.FormatUint(mode, 8)
+		}
+		return strconv.FormatUint(mode, p.base)
+	}
 	if p.fieldKind == reflect.String {
 		return *((*string)(p.paramPointer))
 	}
 	if p.fieldKind == reflect.Int {
 		i := *((*int)(p.paramPointer))
-		return strconv.Itoa(i)
+		if name, ok := p.enumName(i); ok {
+			return name
+		}
+		return strconv.Itoa(i) + p.unit
+	}
+	if isSizedIntKind(p.fieldKind) {
+		return strconv.FormatInt(reflect.NewAt(p.fieldType, p.paramPointer).Elem().Int(), 10)
+	}
+	if isUintKind(p.fieldKind) {
+		return strconv.FormatUint(reflect.NewAt(p.fieldType, p.paramPointer).Elem().Uint(), 10)
+	}
+	if p.fieldKind == reflect.Float64 {
+		return strconv.FormatFloat(*((*float64)(p.paramPointer)), 'g', -1, 64) + p.unit
+	}
+	if p.fieldKind == reflect.Float32 {
+		return strconv.FormatFloat(float64(*((*float32)(p.paramPointer))), 'g', -1, 32) + p.unit
 	}
 	if p.fieldKind == reflect.Bool {
 		if *((*bool)(p.paramPointer)) {
@@ -43,247 +410,2413 @@ func (p param) String() string {
 	return ""
 }
 
+// setParam sets the field to val and records the source it came from.
 func (p *param) setParam(val, configType, keyName string) error {
-	if p.fieldKind == reflect.String {
+	if configType != "default value" {
+		// A fileexists field is set to true without its file being read.
+		if !p.fileExists || configType != "file" {
+			var err error
+			if val, err = p.transformValue(val); err != nil {
+				return err
+			}
+		}
+		p.assignedFrom = append(p.assignedFrom, configType+" "+keyName)
+	}
+	p.isSet = true
+	if err := p.setValue(val, configType, keyName); err != nil {
+		return err
+	}
+	p.source = configType
+	p.sourceKey = keyName
+	return nil
+}
+
+// setParamElems sets a slice field to the given elements and records the
+// source they came from.
+func (p *param) setParamElems(vals []string, configType, keyName string) error {
+	transformed := make([]string, len(vals))
+	for i, val := range vals {
+		var err error
+		if transformed[i], err = p.transformValue(val); err != nil {
+			return err
+		}
+	}
+	vals = transformed
+	p.assignedFrom = append(p.assignedFrom, configType+" "+keyName)
+	p.isSet = true
+	if err := p.setElems(vals, configType, keyName); err != nil {
+		return err
+	}
+	p.source = configType
+	p.sourceKey = keyName
+	return nil
+}
+
+// transformValue returns val after passing it through Options.ValueTransform,
+// if it is set, removing the prefix in the field's requireprefix tag, and
+// then decrypting it if the field has an encrypted tag.
+func (p *param) transformValue(val string) (string, error) {
+	if p.valueTransform != nil {
+		transformed, err := p.valueTransform(p.name, val)
+		if err != nil {
+			return "", fmt.Errorf("error transforming the value of field %s: %w", p.name, err)
+		}
+		val = transformed
+	}
+	if p.requirePrefix != "" {
+		if !strings.HasPrefix(val, p.requirePrefix) {
+			// The value itself is left out as it may be a secret.
+			return "", fmt.Errorf("the value of field %s must start with %s", p.name, p.requirePrefix)
+		}
+		val = val[len(p.requirePrefix):]
+	}
+	if p.encrypted {
+		plaintext, err := p.decryptor(val)
+		if err != nil {
+			return "", fmt.Errorf("error decrypting the value of field %s: %w", p.name, err)
+		}
+		val = plaintext
+	}
+	return val, nil
+}
+
+// fileCandidates returns the names of the files which the field may be set
+// from, in order of preference.
+func (p param) fileCandidates() []string {
+	names := strings.Split(p.filename, ",")
+	for i := range names {
+		names[i] = strings.TrimSpace(names[i])
+	}
+	return names
+}
+
+// stripChars removes the characters in the field's strip tag from val.
+func (p param) stripChars(val string) string {
+	if p.strip == "" {
+		return val
+	}
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(p.strip, r) {
+			return -1
+		}
+		return r
+	}, val)
+}
+
+// isNumericType returns true if t is one of the numeric types which
+// ParseWithDir parses itself, or a slice of these.
+func isNumericType(t reflect.Type) bool {
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	k := t.Kind()
+	return t == fileModeType || k == reflect.Int || k == reflect.Float64 || k == reflect.Float32 || isSizedIntKind(k) || isUintKind(k)
+}
+
+// byteArrayEncoding returns the encoding of the values of a byte array field.
+func (p param) byteArrayEncoding() string {
+	if encoding, err := byteArrayEncoding(p.encodings); err == nil {
+		return encoding
+	}
+	return encodingHex
+}
+
+// isZero returns true if the field holds the zero value for its type, e.g.
+// false for a bool field.
+func (p param) isZero() bool {
+	if p.paramPointer == nil {
+		return true
+	}
+	return reflect.NewAt(p.fieldType, p.paramPointer).Elem().IsZero()
+}
+
+// isSlice returns true if the field is a slice which is set element by
+// element.
+func (p param) isSlice() bool {
+	return p.fieldKind == reflect.Slice && !p.unmarshalJSON && p.converter == nil && !p.structElems
+}
+
+// isMap returns true if the field is a map which is set entry by entry.
+func (p param) isMap() bool {
+	return p.fieldKind == reflect.Map && !p.unmarshalJSON && p.converter == nil
+}
+
+// isSetType returns true if t is a map used as a set, i.e. one whose element
+// type is an empty struct, such as map[string]struct{}.
+func isSetType(t reflect.Type) bool {
+	return t.Kind() == reflect.Map && t.Elem().Kind() == reflect.Struct && t.Elem().NumField() == 0
+}
+
+// isJSONStructType returns true if t is a struct which is not otherwise
+// supported, which can be set from a JSON object.
+func isJSONStructType(t reflect.Type, converters map[reflect.Type]Converter) bool {
+	return t.Kind() == reflect.Struct && !isSupportedType(t, converters)
+}
+
+// setSubFieldsFromEnv sets the fields of a struct field which was set from a
+// JSON object from the environment variables named after the field's
+// environment variable and each sub-field's env tag or uppercase name, e.g.
+// DB_HOST for the Host field of DB. These take precedence over the JSON.
+func (p *param) setSubFieldsFromEnv(opts Options) error {
+	structval := reflect.NewAt(p.fieldType, p.paramPointer).Elem()
+	for i := 0; i < p.fieldType.NumField(); i++ {
+		structfield := p.fieldType.Field(i)
+		subkey := envKeyFor(structfield, p.envKey+"_")
+		if subkey == "" {
+			continue
+		}
+		envval, ok := opts.lookupEnv(subkey)
+		if !ok {
+			continue
+		}
+		sp := valueParam(structfield, structval.Field(i), p.converters)
+		if sp == nil {
+			continue
+		}
+		envval, err := p.transformValue(envval)
+		if err != nil {
+			return err
+		}
+		if err := sp.setValue(envval, "environment variable", subkey); err != nil {
+			return err
+		}
+		p.assignedFrom = append(p.assignedFrom, "environment variable "+subkey)
 		p.isSet = true
+		p.source = "environment variable"
+		p.sourceKey = subkey
+	}
+	return nil
+}
+
+// isStructSliceType returns true if t is a slice of structs which are not
+// otherwise supported, which can only be set from indexed environment
+// variables.
+func isStructSliceType(t reflect.Type, converters map[reflect.Type]Converter) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Struct && !isSupportedType(t, converters)
+}
+
+// elemParam returns a param which can be used to get or set elem, which must
+// be an addressable element of the field's slice.
+func (p param) elemParam(elem reflect.Value) *param {
+	ep := p
+	ep.fieldType = elem.Type()
+	ep.fieldKind = ep.fieldType.Kind()
+	ep.paramPointer = unsafe.Pointer(elem.Addr().Pointer())
+	ep.unmarshalJSON = implementsJSONUnmarshaler(ep.fieldType)
+	ep.converter = converterFor(ep.fieldType, p.converters)
+	return &ep
+}
+
+// setElems replaces the contents of a slice field with vals, parsing each
+// element in turn.
+func (p *param) setElems(vals []string, configType, keyName string) error {
+	slice := reflect.MakeSlice(p.fieldType, len(vals), len(vals))
+	for i, val := range vals {
+		if err := p.elemParam(slice.Index(i)).setValue(val, configType, keyName); err != nil {
+			return fmt.Errorf("element %d of %v", i, err)
+		}
+	}
+	reflect.NewAt(p.fieldType, p.paramPointer).Elem().Set(slice)
+	return nil
+}
+
+// setEntries replaces the contents of a map field with entries, each of which
+// is of the form key=value. Each key and value is parsed according to the
+// map's key and element types. If a key appears more than once, the last
+// value is used. Errors name the entry which could not be parsed. For a set,
+// see isSetType, each entry is just a key.
+func (p *param) setEntries(entries []string, configType, keyName string) error {
+	m := reflect.MakeMapWithSize(p.fieldType, len(entries))
+	if isSetType(p.fieldType) {
+		v := reflect.New(p.fieldType.Elem()).Elem()
+		for _, entry := range entries {
+			k := reflect.New(p.fieldType.Key()).Elem()
+			if err := p.elemParam(k).setValue(entry, configType, keyName); err != nil {
+				return fmt.Errorf("member %q of %v", entry, err)
+			}
+			m.SetMapIndex(k, v)
+		}
+		reflect.NewAt(p.fieldType, p.paramPointer).Elem().Set(m)
+		return nil
+	}
+	for _, entry := range entries {
+		i := strings.IndexByte(entry, '=')
+		if i < 0 {
+			return fmt.Errorf("entry %q of %s %s for field %s must be of the form key=value", entry, configType, keyName, p.name)
+		}
+		k := reflect.New(p.fieldType.Key()).Elem()
+		if err := p.elemParam(k).setValue(strings.TrimSpace(entry[:i]), configType, keyName); err != nil {
+			return fmt.Errorf("key of entry %q of %v", entry, err)
+		}
+		v := reflect.New(p.fieldType.Elem()).Elem()
+		if err := p.elemParam(v).setValue(strings.TrimSpace(entry[i+1:]), configType, keyName); err != nil {
+			return fmt.Errorf("value of entry %q of %v", entry, err)
+		}
+		m.SetMapIndex(k, v)
+	}
+	reflect.NewAt(p.fieldType, p.paramPointer).Elem().Set(m)
+	return nil
+}
+
+// entriesString returns the entries of a map field in the form read by
+// setEntries, sorted so that the result doesn't depend on map iteration
+// order.
+func (p param) entriesString() string {
+	m := reflect.NewAt(p.fieldType, p.paramPointer).Elem()
+	entries := make([]string, 0, m.Len())
+	iter := m.MapRange()
+	for iter.Next() {
+		k := reflect.New(p.fieldType.Key()).Elem()
+		k.Set(iter.Key())
+		if isSetType(p.fieldType) {
+			entries = append(entries, p.elemParam(k).value())
+			continue
+		}
+		v := reflect.New(p.fieldType.Elem()).Elem()
+		v.Set(iter.Value())
+		entries = append(entries, p.elemParam(k).value()+"="+p.elemParam(v).value())
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, p.separator)
+}
+
+// separatorFor returns the separator used to split values from the source
+// named by configType.
+func (p param) separatorFor(configType string) string {
+	switch {
+	case configType == sourceFile && p.fileSeparator != "":
+		return p.fileSeparator
+	case configType == "environment variable" && p.envSeparator != "":
+		return p.envSeparator
+	}
+	return p.separator
+}
+
+// splitElems splits val into trimmed elements using separator. An empty val
+// results in no elements. If separator is whitespace, such as a newline,
+// leading and trailing whitespace is ignored, so that a trailing newline
+// doesn't result in an empty element.
+func (p param) splitElems(val, separator string) []string {
+	if strings.TrimSpace(separator) == "" {
+		val = strings.TrimSpace(val)
+	}
+	if val == "" {
+		return []string{}
+	}
+	elems := strings.Split(val, separator)
+	for i := range elems {
+		elems[i] = strings.TrimSpace(elems[i])
+	}
+	return elems
+}
+
+// setValue parses val and sets the field to it. An error for a field with a
+// secret tag has val replaced with ****.
+func (p *param) setValue(val, configType, keyName string) error {
+	err := p.setRawValue(val, configType, keyName)
+	if err != nil && p.secret && val != "" {
+		return errors.New(strings.ReplaceAll(err.Error(), val, redacted))
+	}
+	return err
+}
+
+func (p *param) setRawValue(val, configType, keyName string) error {
+	p.allocate()
+	if p.converter != nil {
+		v, err := p.converter(val)
+		if err != nil {
+			return fmt.Errorf("%s %s for field %s could not be converted: %v", configType, keyName, p.name, err)
+		}
+		rv := reflect.ValueOf(v)
+		if !rv.IsValid() || !rv.Type().AssignableTo(p.fieldType) {
+			return fmt.Errorf("converter for field %s returned %T instead of %v", p.name, v, p.fieldType)
+		}
+		reflect.NewAt(p.fieldType, p.paramPointer).Elem().Set(rv)
+		return nil
+	}
+	if p.isSlice() {
+		return p.setElems(p.splitElems(val, p.separatorFor(configType)), configType, keyName)
+	}
+	if p.isMap() {
+		return p.setEntries(p.splitElems(val, p.separatorFor(configType)), configType, keyName)
+	}
+	if p.jsonStruct {
+		field := reflect.NewAt(p.fieldType, p.paramPointer)
+		v := reflect.New(p.fieldType)
+		if err := json.Unmarshal([]byte(val), v.Interface()); err != nil {
+			return fmt.Errorf("%s %s for field %s must be a JSON object: %v", configType, keyName, p.name, err)
+		}
+		field.Elem().Set(v.Elem())
+		return nil
+	}
+	if levelTypes[p.fieldType] != nil {
+		return p.setLevel(val, configType, keyName)
+	}
+	if p.unmarshalJSON {
+		// Values which aren't valid JSON are treated as JSON strings, unless
+		// the field is explicitly tagged as JSON.
+		data := []byte(val)
+		if p.format != "json" && !json.Valid(data) {
+			data, _ = json.Marshal(val)
+		}
+		u := reflect.NewAt(p.fieldType, p.paramPointer).Interface().(json.Unmarshaler)
+		if err := u.UnmarshalJSON(data); err != nil {
+			return fmt.Errorf("%s %s for field %s could not be unmarshaled from json: %v", configType, keyName, p.name, err)
+		}
+		return nil
+	}
+	if p.fieldType == durationType {
+		var d time.Duration
+		var err error
+		if p.clockDuration && strings.Contains(val, ":") {
+			d, err = parseClockDuration(val)
+		} else if p.extendedDuration {
+			d, err = parseExtendedDuration(val)
+		} else {
+			d, err = time.ParseDuration(val)
+		}
+		if err != nil {
+			return fmt.Errorf("%s %s for field %s must be a duration - instead it is: %v", configType, keyName, p.name, val)
+		}
+		*(*time.Duration)(p.paramPointer) = d
+		return nil
+	}
+	if p.fieldType == timeType {
+		t, err := p.parseTime(val)
+		if err != nil {
+			return fmt.Errorf("%s %s for field %s %v - instead it is: %v", configType, keyName, p.name, err, val)
+		}
+		*(*time.Time)(p.paramPointer) = t
+		return nil
+	}
+	if isByteArrayType(p.fieldType) {
+		encoding := p.byteArrayEncoding()
+		b, err := decodeContents([]byte(val), []string{encoding})
+		if err != nil {
+			return fmt.Errorf("%s %s for field %s must be %s-encoded - instead it is: %v", configType, keyName, p.name, encoding, val)
+		}
+		if len(b) != p.fieldType.Len() {
+			return fmt.Errorf("%s %s for field %s must decode to %d bytes - instead it decodes to %d", configType, keyName, p.name, p.fieldType.Len(), len(b))
+		}
+		array := reflect.NewAt(p.fieldType, p.paramPointer).Elem()
+		reflect.Copy(array, reflect.ValueOf(b))
+		return nil
+	}
+	if p.fieldType == regexpType {
+		re, err := regexp.Compile(val)
+		if err != nil {
+			return fmt.Errorf("%s %s for field %s must be a regular expression: %v", configType, keyName, p.name, err)
+		}
+		*(**regexp.Regexp)(p.paramPointer) = re
+		return nil
+	}
+	if p.fieldType == fileModeType {
+		val = p.stripChars(val)
+		mode, err := strconv.ParseUint(val, p.base, 32)
+		if err != nil {
+			return fmt.Errorf("%s %s for field %s must be a file mode in base %d - instead it is: %v", configType, keyName, p.name, p.base, val)
+		}
+		*(*os.FileMode)(p.paramPointer) = os.FileMode(mode)
+		return nil
+	}
+	if p.fieldKind == reflect.String && p.logLevel {
+		level, err := logLevel(val)
+		if err != nil {
+			return fmt.Errorf("%s %s for field %s %v - instead it is: %v", configType, keyName, p.name, err, val)
+		}
+		*(*string)(p.paramPointer) = level
+		return nil
+	}
+	if p.fieldKind == reflect.String {
 		*(*string)(p.paramPointer) = val
 		return nil
 	}
+	if p.fieldKind == reflect.Int && p.enum != nil {
+		i, err := p.enumValue(val)
+		if err != nil {
+			return fmt.Errorf("%s %s for field %s %v - instead it is: %v", configType, keyName, p.name, err, val)
+		}
+		*(*int)(p.paramPointer) = i
+		return nil
+	}
+	if p.unit != "" && (p.fieldKind == reflect.Int || p.fieldKind == reflect.Float64 || p.fieldKind == reflect.Float32) {
+		num, err := p.convertUnit(val)
+		if err != nil {
+			return fmt.Errorf("%s %s for field %s %v - instead it is: %v", configType, keyName, p.name, err, val)
+		}
+		val = num
+	}
 	if p.fieldKind == reflect.Int {
-		p.isSet = true
+		val = p.stripChars(val)
 		i, err := strconv.Atoi(val)
 		if err != nil {
 			return fmt.Errorf("%s %s must be an integer - instead it is: %v", configType, keyName, val)
 		}
-		*(*int)(p.paramPointer) = i
-		return nil
-	}
-	if p.fieldKind == reflect.Bool {
-		p.isSet = true
-		l := strings.ToLower(val)
-		bval := true
-		if l == "0" || l == "f" || l == "false" || l == "n" || l == "no" {
-			bval = false
+		*(*int)(p.paramPointer) = i
+		return nil
+	}
+	if isSizedIntKind(p.fieldKind) {
+		val = p.stripChars(val)
+		i, err := strconv.ParseInt(val, 10, p.fieldType.Bits())
+		if err != nil {
+			return fmt.Errorf("%s %s must be an integer of %d bits - instead it is: %v", configType, keyName, p.fieldType.Bits(), val)
+		}
+		reflect.NewAt(p.fieldType, p.paramPointer).Elem().SetInt(i)
+		return nil
+	}
+	if isUintKind(p.fieldKind) {
+		val = p.stripChars(val)
+		u, err := strconv.ParseUint(val, 10, p.fieldType.Bits())
+		if err != nil {
+			return fmt.Errorf("%s %s must be a non-negative integer of %d bits - instead it is: %v", configType, keyName, p.fieldType.Bits(), val)
+		}
+		reflect.NewAt(p.fieldType, p.paramPointer).Elem().SetUint(u)
+		return nil
+	}
+	if p.fieldKind == reflect.Float64 || p.fieldKind == reflect.Float32 {
+		val = p.stripChars(val)
+		if p.decimalComma && strings.Count(val, ",") == 1 {
+			val = strings.Replace(val, ",", ".", 1)
+		}
+		bitSize := 64
+		if p.fieldKind == reflect.Float32 {
+			bitSize = 32
+		}
+		f, err := strconv.ParseFloat(val, bitSize)
+		if err != nil {
+			return fmt.Errorf("%s %s must be a number - instead it is: %v", configType, keyName, val)
+		}
+		if bitSize == 32 {
+			*(*float32)(p.paramPointer) = float32(f)
+		} else {
+			*(*float64)(p.paramPointer) = f
+		}
+		return nil
+	}
+	if p.fieldKind == reflect.Bool {
+		*(*bool)(p.paramPointer) = !isFalse(val)
+		return nil
+	}
+
+	return fmt.Errorf("%s %s is of an unknown type: %v", configType, keyName, val)
+}
+
+// enumValue returns the value of the name val in the field's enum map. Names
+// are matched exactly if possible, and otherwise without regard to ASCII
+// case.
+func (p param) enumValue(val string) (int, error) {
+	if i, ok := p.enum[val]; ok {
+		return i, nil
+	}
+	for name, i := range p.enum {
+		if asciiEqualFold(name, val) {
+			return i, nil
+		}
+	}
+	names := make([]string, 0, len(p.enum))
+	for name := range p.enum {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return 0, fmt.Errorf("must be one of %s", strings.Join(names, ", "))
+}
+
+// enumName returns the name of i in the field's enum map. If several names
+// have the value i, the first in lexical order is returned.
+func (p param) enumName(i int) (string, bool) {
+	found := false
+	var name string
+	for n, v := range p.enum {
+		if v == i && (!found || n < name) {
+			name = n
+			found = true
+		}
+	}
+	return name, found
+}
+
+// timeUnits are the units which a unit tag may convert between.
+var timeUnits = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"µs": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+// convertUnit returns the number in val, which must end with the unit in the
+// field's unit tag. If the unit is a time unit, val may use any other time
+// unit instead, and the number is converted, e.g. 2s is 2000 for a unit of
+// ms.
+func (p param) convertUnit(val string) (string, error) {
+	val = strings.TrimSpace(val)
+	if unit, ok := timeUnits[p.unit]; ok {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return "", fmt.Errorf("must be a number with a time unit such as %s", p.unit)
+		}
+		if p.fieldKind != reflect.Int {
+			return strconv.FormatFloat(float64(d)/float64(unit), 'g', -1, 64), nil
+		}
+		if d%unit != 0 {
+			return "", fmt.Errorf("must be a whole number of %s", p.unit)
+		}
+		return strconv.FormatInt(int64(d/unit), 10), nil
+	}
+	num := strings.TrimSuffix(val, p.unit)
+	if num == val || num == "" {
+		return "", fmt.Errorf("must have the unit %s", p.unit)
+	}
+	return strings.TrimSpace(num), nil
+}
+
+// falseValues are the values which set a bool field to false. Any other value
+// sets it to true.
+var falseValues = []string{"0", "f", "false", "n", "no"}
+
+// isFalse returns true if val matches one of falseValues, ignoring ASCII case.
+// Only ASCII letters are folded, so the result doesn't depend on the locale or
+// on Unicode case folding rules, e.g. "FALSE" matches but "FALſE" doesn't.
+func isFalse(val string) bool {
+	for _, f := range falseValues {
+		if asciiEqualFold(val, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// asciiEqualFold returns true if s and t are equal when the ASCII letters A-Z
+// are mapped to a-z. Other bytes must match exactly.
+func asciiEqualFold(s, t string) bool {
+	if len(s) != len(t) {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if asciiLower(s[i]) != asciiLower(t[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func asciiLower(b byte) byte {
+	if 'A' <= b && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
+
+// parseTime parses val as a Unix timestamp if the field has a unixtime tag, or
+// with the field's layout otherwise. The returned error describes what val
+// should have been.
+func (p param) parseTime(val string) (time.Time, error) {
+	if p.unixTime == "" {
+		t, err := time.Parse(p.layout, val)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("must be a time in the format %s", p.layout)
+		}
+		return t, nil
+	}
+
+	i, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("must be a Unix timestamp in %s", unixTimeUnits[p.unixTime])
+	}
+	switch p.unixTime {
+	case "s":
+		return time.Unix(i, 0), nil
+	case "ms":
+		return time.UnixMilli(i), nil
+	case "us":
+		return time.UnixMicro(i), nil
+	}
+	return time.Unix(0, i), nil
+}
+
+// unixTimeUnits maps the values accepted by the unixtime tag to their
+// descriptions.
+var unixTimeUnits = map[string]string{
+	"s":  "seconds",
+	"ms": "milliseconds",
+	"us": "microseconds",
+	"ns": "nanoseconds",
+}
+
+func (p *param) Set(s string) error {
+	if p.negatedSeen {
+		return fmt.Errorf("conflicts with -%s%s", negatedFlagPrefix, p.flagKey)
+	}
+	if p.count && s == "true" {
+		// Each occurrence of a count flag without a value adds one to the
+		// count from the command line so far.
+		n := 0
+		if p.flagSeen {
+			n, _ = strconv.Atoi(p.flagValue)
+		}
+		s = strconv.Itoa(n + 1)
+	}
+	p.flagValue = s
+	p.flagSeen = true
+	return p.setParam(s, "command line flag", p.flagKey)
+}
+
+func (p param) IsBoolFlag() bool {
+	return (p.fieldKind == reflect.Bool || p.count) && !p.unmarshalJSON && p.converter == nil
+}
+
+// negatedFlagPrefix is prepended to a bool field's flag to get the name of the
+// flag which sets it to false. See Options.GenerateNegatedBoolFlags.
+const negatedFlagPrefix = "no-"
+
+// negatedFlag is the flag.Value of the flag which sets a bool field to false.
+type negatedFlag struct {
+	p *param
+}
+
+func (n *negatedFlag) String() string {
+	return "false"
+}
+
+func (n *negatedFlag) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	if n.p.flagSeen && !n.p.negatedSeen {
+		return fmt.Errorf("conflicts with -%s", n.p.flagKey)
+	}
+	n.p.negatedSeen = true
+	n.p.flagValue = strconv.FormatBool(!b)
+	n.p.flagSeen = true
+	return n.p.setParam(n.p.flagValue, "command line flag", negatedFlagPrefix+n.p.flagKey)
+}
+
+func (n *negatedFlag) IsBoolFlag() bool {
+	return true
+}
+
+// Parse will take in a pointer to a struct and set each field to an
+// environment variable or a flag from the command line. The environment
+// variable will take precedence over the command line flag.
+//
+// Parse will invoke ParseWithDir with dir set to an empty string.
+//
+func Parse(ptrtostruct interface{}) error {
+	return ParseWithDir(ptrtostruct, "")
+}
+
+// ParseWithDir will take in a pointer to a struct and set each field to a
+// value in the a file, environment variable, or a flag from the command line.
+// The file will take precedence over the environment variable and the
+// environment variable will take precedence over the command line flag.
+//
+// If flag.CommandLine uses flag.ContinueOnError, an error parsing the command
+// line flags is returned. The error for a flag which doesn't correspond to any
+// field names the flag.
+//
+// If a field is of type bool, it will be set to true as long as the
+// corresponding environment variable is set, unless the environment
+// variable's value is 0, f, false, n or no. These values are matched without
+// regard to ASCII case, independently of the locale. On the command line, a
+// bool flag such as -async doesn't take the following argument as its value,
+// so -async false sets the field to true and leaves false as a positional
+// argument. Use -async=false instead, or set Options.SpaceSeparatedBoolFlags.
+//
+// Set the appropriate tag in each field to tell ParseWithDir how to handle the
+// field. ParseWithDir accepts the following tags: file, fileexists, relfile,
+// filepath, env, envindexed, flag, noenv, noflag, default, usage, example,
+// secret, mandatory, mandatoryif, deprecated, separator, filesep, envsep,
+// extendedduration, clockduration, layout, layoutname, unixtime, base, format,
+// encoding, strip, decimalcomma, unit, loglevel, count, keyring, requireprefix,
+// encrypted, envjoin, joinsep, buildinfo, sources, lazysecret, min, max, oneof,
+// pattern, checksum, equalto, minitems, maxitems, multipleof, path, group,
+// grouppolicy.
+//
+// The file tag specifies the name of the file in dir which corresponds to the
+// field. If this is not specified, ParseWithDir uses the lowercase version of
+// the field name. Files are only consulted if dir is not empty. Files may be
+// in subdirectories of dir, and are usually identified by their name alone. A
+// file tag containing forward slashes, e.g. file:"tls/key", is instead a path
+// relative to dir, and works with any operating system's path separator. The
+// tag may list several candidate files separated by commas, e.g.
+// file:"config.local,config", in which case the field is set from the first
+// of them which exists in dir, in the order they are listed. A file tag may
+// also be a pattern, using the syntax of path.Match, e.g. file:"ca-*". The
+// field is then set to the contents of every matching file concatenated
+// together, in lexical order of the files' names.
+//
+// A single trailing newline, as added by most editors and by echo, is removed
+// from a file's contents before they are used, unless the field has an
+// encoding tag or a file tag which is a pattern. Set
+// Options.TrimFileWhitespace to remove all trailing whitespace instead.
+//
+// The fileexists tag can only be used on bool fields. It tells ParseWithDir to
+// set the field to true if the field's file exists, irrespective of the file's
+// contents, and to false if the file does not exist. The field has no
+// environment variable or command line flag, and cannot have a default tag.
+//
+// The relfile tag specifies the path of a file, relative to the current
+// working directory, which corresponds to the field, e.g. relfile:"VERSION".
+// It is independent of dir and is consulted if the field's file in dir does
+// not exist. If the relfile does not exist either, the field falls through to
+// the environment variable and command line flag.
+//
+// The filepath tag specifies the absolute path of a file which corresponds to
+// the field, e.g. filepath:"/run/credentials/myapp/token". It is consulted
+// after the relfile, and if the file does not exist, the field falls through
+// to the environment variable and command line flag. A value of the form fd:N
+// reads the field from the already open file descriptor N instead, e.g.
+// filepath:"fd:3" for a credential passed by systemd. The file descriptor is
+// read to the end and closed, and ParseWithDir returns an error if it cannot
+// be read.
+//
+// The keyring tag specifies an entry in the OS keyring, as service/account,
+// e.g. keyring:"myapp/token". It is consulted after the environment variable,
+// using Options.KeyringGetter, and if the entry does not exist, the field
+// falls through to the command line flag. The tag has no effect without a
+// KeyringGetter.
+//
+// The encoding tag specifies how the contents of the field's file are
+// encoded. It may be base64, hex or gzip, or a comma-separated list of these
+// which are decoded in the order they are listed, e.g. encoding:"base64,gzip"
+// for a file holding base64-encoded gzip data. The contents are decoded before
+// any other processing. The tag only applies to files, not to environment
+// variables or command line flags, except on byte array fields.
+//
+// Fields which are arrays of bytes, such as [32]byte for a key, are set from
+// hex-encoded values, or base64-encoded values if the field has an
+// encoding:"base64" tag. This applies to values from every source. The
+// decoded value must be exactly as long as the array.
+//
+// The env tag specifies the environment variable name which corresponds to
+// the field. If this is not specified, ParseWithDir uses the uppercase version
+// of the field name.
+//
+// The flag tag specifies the command line flag name which corresponds to the
+// field. If this is not specified, ParseWithDir uses the lowercase version of
+// the field name.
+//
+// The noenv and noflag tags stop ParseWithDir from looking up the field in the
+// environment or registering a command line flag for it respectively. As with
+// the mandatory tag, it doesn't matter what value these tags are set to.
+//
+// The default tag specifies a default value for the field. This value is used
+// if the corresponding environment variable and command line flag do not
+// exist.
+//
+// The mandatory tag marks the field as mandatory. If the corresponding
+// environment variable and command line flag do not exist, ParseWithDir will
+// print an error message and the usage to stderr and return a
+// *MissingMandatoryError. The struct still holds the values of all the other
+// fields, so that it can be shown for diagnosis, but shouldn't otherwise be
+// trusted. ParseWithDir will assume that the field is mandatory as long as the
+// tag exists - it doesn't matter what value the tag is set to. A mandatory
+// field must have at least one source it can be set from - if it is tagged with
+// both noenv and noflag, has no relfile or filepath tag, and there is no config
+// directory, ParseWithDir will return an error.
+//
+// The mandatoryif tag makes the field mandatory only if the field named in the
+// tag is not the zero value after all fields have been resolved, e.g.
+// mandatoryif:"TLSEnabled" on TLSCert requires TLSCert only if TLSEnabled is
+// true. The named field must be another field in the struct which
+// ParseWithDir can set. A field cannot have both a mandatory and a
+// mandatoryif tag.
+//
+// The sources tag replaces the usual order of precedence for the field with
+// the comma-separated list of sources in the tag, which are consulted in the
+// order they are listed until one of them has a value, e.g.
+// sources:"flag,env,default". The sources are file, relfile, filepath, env
+// (which includes envindexed), keyring, flag, document (for ParseReader) and
+// default.
+// Sources which aren't listed are never consulted, so the field has no
+// command line flag unless flag is listed.
+//
+// The lazysecret tag can only be used on fields of type
+// func() (string, error). Instead of reading the field's value, ParseWithDir
+// sets the field to a function which reads the value from the field's file or
+// environment variable each time it is called, falling back to its default.
+// The function returns an error if none of these has a value. Such fields
+// have no command line flag, so that secrets don't appear in the process's
+// arguments, and they always count as set for the mandatory tag.
+//
+// The usage tag specifies the usage text for the command line flag.
+//
+// The example tag holds an example value for the field, e.g. example:"8080",
+// which is shown by GenerateMarkdownDocs. It is never used as the field's
+// value.
+//
+// The secret tag marks a field whose value is sensitive. Its value is shown
+// as **** wherever ParseWithDir would otherwise show it, such as the default
+// value in the usage text, errors for values which can't be parsed and the
+// errors for the min, max, oneof and pattern tags. It is also hidden in the
+// changes returned by Reload and the map returned by DiffFromDefaults. The
+// field itself is set as usual.
+//
+// The requireprefix tag specifies a prefix which each value read for the
+// field from a config source must start with, e.g. requireprefix:"secret://".
+// The prefix is removed before the value is used, and a value without it is
+// an error. Default values don't need the prefix.
+//
+// The encrypted tag marks a field whose values are encrypted at rest. Each
+// value read from a config source is passed to Options.Decryptor, which must
+// be set, and the plaintext it returns is used instead. Default values are
+// not decrypted.
+//
+// The envjoin tag lists environment variables, separated by commas, whose
+// values are joined to form the field's value if it isn't set from any other
+// source apart from its default, e.g. envjoin:"FIRST,LAST" joinsep:" ". The
+// values are joined with the joinsep tag's value, or with nothing if there is
+// no joinsep tag. Variables which aren't set are skipped, and the field is
+// left alone if none of them are.
+//
+// The buildinfo tag names a setting in the build info embedded in the binary
+// by the go command, e.g. buildinfo:"vcs.revision" or buildinfo:"vcs.time",
+// which the field is set from if it isn't set from any other source apart
+// from its default. The field is left alone if the binary has no build info
+// or the setting isn't in it. See runtime/debug.ReadBuildInfo.
+//
+// If Options.DefaultsDir is set, a field which still isn't set from any
+// source apart from its default is read from its file in that directory, e.g.
+// defaults/db_host next to the executable.
+//
+// A default tag may refer to environment variables as ${VAR}, which is
+// replaced with the value of VAR, or ${VAR:-fallback}, which is replaced with
+// fallback if VAR is unset or empty, e.g.
+// default:"${DATA_DIR:-/var/lib/app}/db". A variable without a fallback which
+// is unset is replaced with an empty string, or results in an error if
+// Options.ErrorOnUnsetDefaultVars is set. Other uses of $ are left alone. The
+// variables are looked up without Options.EnvPrefix.
+//
+// The deprecated tag marks the field as deprecated. The field works as usual,
+// but if it is set from any source other than its default, the tag's value is
+// logged as a warning, e.g. deprecated:"use HOST instead".
+//
+// Fields of type time.Duration are parsed with time.ParseDuration. If the
+// extendedduration tag exists, the field's value may also use the d (day) and
+// w (week) units, which are treated as 24 and 168 hours respectively. If the
+// clockduration tag exists, the field's value may also be written as clock
+// time, i.e. HH:MM:SS or MM:SS, e.g. 01:30:00 is 90 minutes.
+//
+// The count tag can only be used on int fields, and makes the field's command
+// line flag count how many times it appears, for flags such as -v -v -v. The
+// flag doesn't need a value, and each occurrence without one adds one to the
+// count, starting from zero rather than the field's default. A value sets the
+// count, e.g. -v=3. Environment variables and files set the number directly
+// instead, e.g. VERBOSE=3, and take precedence over the flag as usual.
+//
+// The min and max tags can only be used on integer, float and time.Duration
+// fields. They specify the smallest and largest value the field may have,
+// e.g. min:"1" max:"65535", or min:"1s" for a time.Duration. The oneof tag can
+// only be used on string and int fields, and lists the values the field may
+// have, separated by commas, e.g. oneof:"debug,info,warn". The pattern tag can
+// only be used on string fields, and is a regular expression which the whole
+// of the field's value must match, e.g. pattern:"[a-z]+". These constraints
+// are only checked for fields which have a value from some source, and a
+// default value which doesn't satisfy them results in an error before
+// anything else is parsed.
+//
+// The checksum tag can only be used on string fields. It names a hash
+// function and another string field which holds the expected checksum of the
+// field's value, in hex, e.g. checksum:"sha256:TokenSHA256". The supported
+// hash functions are md5, sha1, sha256 and sha512. ParseWithDir returns an
+// error if the checksum of the resolved value doesn't match, unless both
+// fields are empty.
+//
+// The equalto tag can only be used on string, integer and bool fields. It
+// names another field of the same type which the field's resolved value must
+// be equal to, e.g. equalto:"Password" on a PasswordConfirmation field.
+//
+// The minitems and maxitems tags can only be used on slice fields. They
+// specify the smallest and largest number of elements the resolved slice may
+// have, e.g. minitems:"1" maxitems:"5". An empty slice is allowed despite
+// minitems unless the field is mandatory.
+//
+// The multipleof tag can only be used on int fields. It requires the field's
+// value to be a multiple of the tag's value, e.g. multipleof:"4096". A value
+// of zero is always allowed. The value is checked after it has been resolved
+// from all sources, and ParseWithDir returns an error if it doesn't comply.
+//
+// The path tag can only be used on string fields which hold a file path. With
+// path:"mustexist", ParseWithDir returns an error if nothing exists at the
+// resolved path. path:"mustexist,readable" additionally requires the path to
+// be readable. An empty value is not checked - use the mandatory tag to
+// require one.
+//
+// The group and grouppolicy tags constrain a set of related fields. Fields
+// with the same group tag belong to the same group, and the grouppolicy tag on
+// any member of the group specifies the constraint. The only policy is
+// allornone, which requires either every field in the group to be set or none
+// of them, e.g. group:"tls" grouppolicy:"allornone" on both TLSCert and
+// TLSKey. A field counts as set if it has a value from any source, including
+// its default.
+//
+// Slice fields are set from a list of values separated by commas, e.g.
+// "a,b,c". Whitespace around each value is ignored. The separator tag
+// specifies a different separator. The filesep and envsep tags specify the
+// separator for values read from files and environment variables
+// respectively, overriding the separator tag, e.g. filesep:"\n" for a file
+// with one value per line. Each value is parsed according to the slice's
+// element type, which may be any of the other supported types. The elements
+// keep the order they are given in, and duplicates are kept.
+//
+// Map fields are set from a list of key=value entries separated by commas,
+// e.g. "a=1,b=2", or by the separator in the separator tag. Whitespace around
+// each key and value is ignored, and each is parsed according to the map's
+// key and element types. If a key appears more than once, the last value is
+// used. An error for an entry which cannot be parsed quotes the entry.
+//
+// A map whose element type is struct{}, such as map[string]struct{}, is a set.
+// It is set from a list of members separated by commas, e.g. "admin,ops", or
+// by the separator in the separator tag, and a member which appears more than
+// once is only added once.
+//
+// The envindexed tag can only be used on slice fields. It specifies a prefix
+// for a series of environment variables which hold the slice's elements, e.g.
+// envindexed:"SERVER" collects SERVER_0, SERVER_1, SERVER_2 and so on, in
+// order. Collection starts at index 0 and stops at the first index which does
+// not exist, so SERVER_3 is ignored if SERVER_2 is not set. If SERVER_0 is not
+// set, the field falls back to its ordinary environment variable.
+//
+// A slice of structs can only be set with an envindexed tag. Each element is
+// built from the variables prefix_N_KEY, where N is the element's index and
+// KEY is the env tag or the uppercase name of one of the struct's fields, e.g.
+// envindexed:"UPSTREAM" on a []Upstream field collects UPSTREAM_0_HOST,
+// UPSTREAM_0_PORT, UPSTREAM_1_HOST and so on. Collection stops at the first
+// index for which no variable starting with prefix_N_ is set. Within the
+// struct, only the env, default, separator, extendedduration, layout and
+// unixtime tags are used. Such fields have no file or command line flag.
+//
+// Fields of type time.Time are parsed with time.Parse, using the layout in the
+// layout tag, or time.RFC3339 if there is no layout tag. Instead of a layout
+// tag, the layoutname tag can refer to a layout registered in
+// Options.TimeLayouts, e.g. layoutname:"iso", so that several fields can share
+// it. A name which isn't registered is an error. Alternatively, the unixtime
+// tag specifies that the value is a Unix timestamp, in the unit given by the
+// tag's value: s, ms, us or ns. A field cannot have both a layout and a
+// unixtime tag. These tags also apply to the elements of a []time.Time field,
+// e.g. "2021-03-04T05:06:07Z,2021-03-05T05:06:07Z". A layout which contains a
+// comma needs a separator tag as well.
+//
+// Fields of type *regexp.Regexp are set by compiling the value with
+// regexp.Compile, e.g. "^/api/" for an Include field. An invalid regular
+// expression results in an error naming the field.
+//
+// Fields of type os.FileMode are parsed as octal numbers, e.g. 0755. The base
+// tag specifies a different base, from 2 to 36. With base:"0", the base is
+// implied by the value's prefix, as with Go integer literals, so 0755, 0o755
+// and 493 are all equivalent.
+//
+// Fields of the sized integer types, e.g. int64, and the unsigned integer
+// types, e.g. uint32, are parsed in base 10 with strconv.ParseInt and
+// strconv.ParseUint, so a value which doesn't fit, or a negative value for an
+// unsigned field, is an error. A []byte field is not supported.
+//
+// Pointer fields of type *string, *int and *bool distinguish a field which
+// wasn't set from one set to the zero value, e.g. a Port *int field set to 0.
+// The field is pointed at a new value when it is set from any source,
+// including its default, and is left nil otherwise.
+//
+// Fields of type float64 and float32 are parsed with strconv.ParseFloat. If
+// the decimalcomma tag exists, a value containing a single comma has it
+// replaced with a decimal point first, so 3,14 is parsed as 3.14. A field with
+// a decimalcomma tag cannot also strip commas, and a slice of floats with a
+// decimalcomma tag needs a separator other than a comma.
+//
+// The strip tag can only be used on numeric fields, i.e. integer, float and
+// os.FileMode fields and slices of these. It lists characters which are
+// removed from the value before it is parsed, e.g. strip:"-" parses 1-800 as
+// 1800.
+//
+// The unit tag can only be used on int and float fields and slices of these.
+// Values must end with the unit, which is removed before the number is parsed,
+// e.g. unit:"ms" parses 500ms as 500. If the unit is a time unit, i.e. ns, us,
+// ms, s, m or h, values may use any time unit and are converted, so 2s is
+// parsed as 2000 with unit:"ms". A value without the unit is an error.
+//
+// The loglevel tag can only be used on string fields and slices of strings.
+// Values must be one of the log levels debug, info, warn or error, matched
+// without regard to case, and are stored in lowercase. slog.Level fields are
+// supported without a tag, and are set from the same level names, or any
+// other name accepted by slog.Level's UnmarshalText, e.g. warn+2.
+//
+// A struct field with a format:"json" tag is set by unmarshaling its value as
+// a JSON object, e.g. DB={"host":"x","port":5}. Afterwards, each of the
+// struct's fields can be overridden by an environment variable named after
+// the field's environment variable and the sub-field's env tag or uppercase
+// name, e.g. DB_PORT for the Port field of DB.
+//
+// Fields whose type implements json.Unmarshaler are set by calling
+// UnmarshalJSON. If the value is not valid JSON, it is passed to
+// UnmarshalJSON as a JSON string. A format:"json" tag makes ParseWithDir pass
+// the value through as-is, so invalid JSON results in an error.
+//
+func ParseWithDir(ptrtostruct interface{}, dir string) error {
+	return ParseWithFlagSet(ptrtostruct, dir, flag.CommandLine, os.Args[1:])
+}
+
+// ParseWithFlagSet behaves like ParseWithDir, but registers the command line
+// flags with fs and parses them from args, which doesn't include the program
+// name, instead of using flag.CommandLine and os.Args[1:]. The usage is
+// printed with fs's Usage function if it has one. This lets the package be
+// used in a library without touching the global flag set, or parse more than
+// once in a process with a new flag set each time. A nil args is treated as
+// empty.
+func ParseWithFlagSet(ptrtostruct interface{}, dir string, fs *flag.FlagSet, args []string) error {
+	return NewParser().WithDir(dir).WithFlagSet(fs, args).Parse(ptrtostruct)
+}
+
+// ParseWithDirs behaves like ParseWithDir, but walks each of dirs to find
+// files, in order of increasing precedence, so a file in a later directory
+// takes precedence over a file of the same name in an earlier one. This
+// allows config to be layered, e.g. site-wide defaults overridden by a
+// deployment's own files. File tags containing forward slashes are looked up
+// in each directory in the same way. Set Options.Result with
+// Parser.WithOptions and use Parser.WithDirs to find out which directory each
+// field's value came from.
+func ParseWithDirs(ptrtostruct interface{}, dirs ...string) error {
+	return NewParser().WithDirs(dirs...).Parse(ptrtostruct)
+}
+
+// ParseWithProfile behaves like ParseWithDir, but files in the subdirectory of
+// dir named profile take precedence over the other files in dir, e.g. with a
+// profile of production, dir/production/port is used instead of dir/port.
+// This allows the config for several environments to be kept in one tree. If
+// the profile's subdirectory doesn't exist, or profile is empty, only dir is
+// used.
+//
+// Files are found by name in the profile's subdirectory and all of its
+// subdirectories, as with ParseWithDir, but only directly in dir, so that
+// another profile's files are never used. Files in the other subdirectories
+// of dir can still be referred to by a file tag containing a forward slash,
+// e.g. file:"tls/key".
+func ParseWithProfile(ptrtostruct interface{}, dir, profile string) error {
+	files, err := scanProfile(dir, profile)
+	if err != nil {
+		return err
+	}
+	return NewParser().WithFileMap(files).Parse(ptrtostruct)
+}
+
+// ParseAndClose behaves like ParseWithDir, then replaces flag.CommandLine with
+// a new, empty flag set, even if parsing fails. The new flag set has the same
+// name, error handling and output as the old one. This discards the flags
+// registered for the struct, so that ParseAndClose can be called again, and
+// other code using the flag package isn't affected by them.
+func ParseAndClose(ptrtostruct interface{}, dir string) error {
+	defer resetCommandLine()
+	return ParseWithDir(ptrtostruct, dir)
+}
+
+// resetCommandLine replaces flag.CommandLine with an empty flag set
+// configured in the same way.
+func resetCommandLine() {
+	old := flag.CommandLine
+	flag.CommandLine = flag.NewFlagSet(old.Name(), old.ErrorHandling())
+	flag.CommandLine.SetOutput(old.Output())
+}
+
+// Freeze removes the flags registered by ParseWithDir and the other Parse
+// functions from flag.CommandLine, so that the parsed structs can no longer be
+// changed through it, e.g. by a later call to flag.Parse. Flags registered by
+// other code are kept, but flag.CommandLine is replaced with a new flag set
+// configured in the same way, so flag.Parsed reports false and flag.Visit
+// visits no flags until it is parsed again.
+func Freeze() {
+	old := flag.CommandLine
+	resetCommandLine()
+	old.VisitAll(func(f *flag.Flag) {
+		switch f.Value.(type) {
+		case *param, *negatedFlag:
+			return
+		}
+		flag.CommandLine.Var(f.Value, f.Name, f.Usage)
+	})
+}
+
+// ParseDeterministic behaves like ParseWithDir, but doesn't depend on the
+// process's environment or command line. Environment variables are looked up
+// in env, and the command line flags are parsed from args, which doesn't
+// include the program name. The flags are registered with a new flag set
+// which uses flag.ContinueOnError, so flag.CommandLine is left alone. A nil
+// env or args is treated as empty. This is mostly useful in tests.
+func ParseDeterministic(ptrtostruct interface{}, dir string, env map[string]string, args []string) error {
+	if env == nil {
+		env = map[string]string{}
+	}
+	if args == nil {
+		args = []string{}
+	}
+	pr := NewParser().WithDir(dir)
+	pr.opts.env = env
+	pr.opts.args = args
+	pr.opts.flagSet = flag.NewFlagSet("", flag.ContinueOnError)
+	return pr.Parse(ptrtostruct)
+}
+
+// ParseWithOptions behaves like ParseWithDir, with its behavior customized by
+// opts.
+func ParseWithOptions(ptrtostruct interface{}, dir string, opts Options) error {
+	return NewParser().WithDir(dir).WithOptions(opts).Parse(ptrtostruct)
+}
+
+// ParseWithFileMap behaves like ParseWithDir, but reads files from fileMap
+// instead of walking a config directory. fileMap maps file names to file
+// paths, as returned by ScanDir, which allows a config directory to be walked
+// once and reused to parse several structs. A nil fileMap disables files as a
+// source.
+func ParseWithFileMap(ptrtostruct interface{}, fileMap map[string]string) error {
+	return NewParser().WithFileMap(fileMap).Parse(ptrtostruct)
+}
+
+// parse does the work for the exported parse functions. If the parser has no
+// file map and its config directory is not empty, the directory is walked to
+// build the file map. Files are only used as a source if there is a file map.
+// document holds values decoded from a structured document, keyed by
+// documentKey. Document values take precedence over defaults but not over any
+// other source.
+func (pr *Parser) parse(ptrtostruct interface{}, document map[string]string) error {
+	return pr.complete(pr.parseStruct(ptrtostruct, document))
+}
+
+// complete reports err, the outcome of a parse, to Options.OnComplete and
+// returns it.
+func (pr *Parser) complete(err error) error {
+	if pr.opts.OnComplete != nil {
+		pr.opts.OnComplete(err)
+	}
+	return err
+}
+
+// parseStruct does the work for parse.
+func (pr *Parser) parseStruct(ptrtostruct interface{}, document map[string]string) error {
+	structval, err := structValue(ptrtostruct)
+	if err != nil {
+		return err
+	}
+
+	opts := pr.opts
+	opts.fsys = pr.fsys
+	if opts.Timeout > 0 {
+		opts.deadline = time.Now().Add(opts.Timeout)
+	}
+	metrics := opts.Metrics
+	var start time.Time
+	if metrics != nil {
+		*metrics = ParseMetrics{}
+	}
+	if opts.Result != nil {
+		*opts.Result = ParseResult{}
+	}
+
+	// The config directory may come from a command line flag, so it is only
+	// walked after the flags have been parsed. Until then, we only need to
+	// know whether there might be any files.
+	configFiles := pr.fileMap
+	filesEnabled := configFiles != nil || pr.fsys != nil || len(pr.dirs) > 0 || pr.dir != "" || pr.dirEnvKey != "" || pr.dirFlagKey != "" || opts.DefaultsDir != ""
+
+	// params is local to this parse, so that concurrent parses don't share
+	// it. The flags registered below refer to its elements.
+	var params []*param
+	structtype := structval.Type()
+	fieldcount := structtype.NumField()
+
+	// We'll loop through the parameters twice - once for the command line
+	// flags, and another for the files and environment variables. This is
+	// because the files and environment variables take precedence over
+	// command line flags.
+	for i := 0; i < fieldcount; i++ {
+		structfield := structtype.FieldByIndex([]int{i})
+		structfieldkind := structfield.Type.Kind()
+
+		// We only support fields of type string, integers, bool, time.Duration,
+		// time.Time, *regexp.Regexp, types which implement json.Unmarshaler,
+		// types with a registered converter, and slices of these.
+		_, hasenvindexed := structfield.Tag.Lookup("envindexed")
+		structelems := isStructSliceType(structfield.Type, opts.converters)
+		if structelems && !hasenvindexed {
+			opts.logf("skipping field %v because it is a slice of structs without an envindexed tag", structfield.Name)
+			continue
+		}
+		_, haslazysecret := structfield.Tag.Lookup("lazysecret")
+		lazysecret := structfield.Type == lazySecretType
+		if haslazysecret && !lazysecret {
+			return fmt.Errorf("field %v has a lazysecret tag but is not a func() (string, error)", structfield.Name)
+		}
+		lazysecret = lazysecret && haslazysecret
+		jsonstruct := structfield.Tag.Get("format") == "json" && isJSONStructType(structfield.Type, opts.converters)
+		if !structelems && !lazysecret && !jsonstruct && !isSupportedType(structfield.Type, opts.converters) {
+			if opts.StrictTypes {
+				if opts.OnUnknownField == nil {
+					return fmt.Errorf("field %v is of unsupported type %v", structfield.Name, structfield.Type)
+				}
+				if err := opts.OnUnknownField(structfield.Name, structfield.Type.Kind()); err != nil {
+					return err
+				}
+			}
+			opts.logf("skipping field %v because it is not of a supported type", structfield.Name)
+			continue
+		}
+
+		// Skip invalid fields and fields that cannot be set.
+		field := structval.FieldByIndex([]int{i})
+		if !field.IsValid() || !field.CanSet() {
+			opts.logf("skipping field %v because it is not valid or cannot be set", structfield.Name)
+			continue
+		}
+
+		// Skip field if this field cannot be converted to a pointer (necessary
+		// for flag call).
+		if !field.CanAddr() {
+			opts.logf("skipping field %v because it cannot be converted to a pointer", structfield.Name)
+			continue
+		}
+
+		// A pointer field is parsed as the type it points to.
+		fieldtype := structfield.Type
+		if isPointerType(fieldtype) {
+			fieldtype = fieldtype.Elem()
+			structfieldkind = fieldtype.Kind()
+		}
+		parampointer, fieldpointer := fieldPointers(field)
+
+		filename := structfield.Tag.Get("file")
+		if filesEnabled && !structelems {
+			if filename == "" {
+				filename = strings.ToLower(structfield.Name)
+			}
+		} else {
+			filename = ""
+		}
+
+		envkey := ""
+		flagkey := ""
+		if !structelems {
+			envkey = envKeyFor(structfield, opts.EnvPrefix)
+		}
+		if !structelems && !lazysecret {
+			flagkey = flagKeyFor(structfield)
+		}
+
+		_, fileexists := structfield.Tag.Lookup("fileexists")
+		if fileexists && structfieldkind != reflect.Bool {
+			return fmt.Errorf("field %v has a fileexists tag but is not a bool", structfield.Name)
+		}
+		if fileexists {
+			// A fileexists field is only set from its file.
+			if _, ok := structfield.Tag.Lookup("default"); ok {
+				return fmt.Errorf("field %v cannot have both a fileexists and a default tag", structfield.Name)
+			}
+			envkey, flagkey = "", ""
+		}
+
+		_, extendedduration := structfield.Tag.Lookup("extendedduration")
+		if extendedduration && fieldtype != durationType {
+			return fmt.Errorf("field %v has an extendedduration tag but is not a time.Duration", structfield.Name)
+		}
+		_, clockduration := structfield.Tag.Lookup("clockduration")
+		if clockduration && fieldtype != durationType {
+			return fmt.Errorf("field %v has a clockduration tag but is not a time.Duration", structfield.Name)
+		}
+
+		layout, haslayout := structfield.Tag.Lookup("layout")
+		layoutname, haslayoutname := structfield.Tag.Lookup("layoutname")
+		unixtime, hasunixtime := structfield.Tag.Lookup("unixtime")
+		istime := fieldtype == timeType || (structfieldkind == reflect.Slice && fieldtype.Elem() == timeType)
+		if (haslayout || haslayoutname || hasunixtime) && !istime {
+			return fmt.Errorf("field %v has a layout, layoutname or unixtime tag but is not a time.Time or a []time.Time", structfield.Name)
+		}
+		if haslayoutname {
+			if haslayout {
+				return fmt.Errorf("field %v cannot have both a layout and a layoutname tag", structfield.Name)
+			}
+			var ok bool
+			if layout, ok = opts.TimeLayouts[layoutname]; !ok {
+				return fmt.Errorf("field %v has a layoutname tag which is not in Options.TimeLayouts: %v", structfield.Name, layoutname)
+			}
+			haslayout = true
+		}
+		if haslayout && hasunixtime {
+			return fmt.Errorf("field %v cannot have both a layout and a unixtime tag", structfield.Name)
+		}
+		if _, ok := unixTimeUnits[unixtime]; hasunixtime && !ok {
+			return fmt.Errorf("field %v has an unknown unixtime unit: %v", structfield.Name, unixtime)
+		}
+		if layout == "" {
+			layout = time.RFC3339
+		}
+
+		base := 8
+		if b, ok := structfield.Tag.Lookup("base"); ok {
+			if fieldtype != fileModeType {
+				return fmt.Errorf("field %v has a base tag but is not an os.FileMode", structfield.Name)
+			}
+			var err error
+			base, err = strconv.Atoi(b)
+			if err != nil || base == 1 || base < 0 || base > 36 {
+				return fmt.Errorf("field %v has an invalid base: %v", structfield.Name, b)
+			}
+		}
+
+		format := structfield.Tag.Get("format")
+		if format != "" && format != "json" {
+			return fmt.Errorf("field %v has an unsupported format %q", structfield.Name, format)
+		}
+		unmarshaljson := implementsJSONUnmarshaler(fieldtype)
+		if format == "json" && !unmarshaljson && !jsonstruct {
+			return fmt.Errorf("field %v has a json format tag but is neither a struct nor implements json.Unmarshaler", structfield.Name)
+		}
+
+		var encodings []string
+		if encoding, ok := structfield.Tag.Lookup("encoding"); ok {
+			var err error
+			if encodings, err = parseEncodings(encoding); err != nil {
+				return fmt.Errorf("field %v has an invalid encoding tag: %v", structfield.Name, err)
+			}
+			if isByteArrayType(fieldtype) {
+				if _, err := byteArrayEncoding(encodings); err != nil {
+					return fmt.Errorf("field %v has an invalid encoding tag: %v", structfield.Name, err)
+				}
+			}
+		}
+
+		strip := structfield.Tag.Get("strip")
+		if strip != "" && (!isNumericType(fieldtype) || unmarshaljson || converterFor(fieldtype, opts.converters) != nil) {
+			return fmt.Errorf("field %v has a strip tag but is not numeric", structfield.Name)
+		}
+
+		unit := structfield.Tag.Get("unit")
+		if unit != "" {
+			elemkind := structfieldkind
+			if elemkind == reflect.Slice {
+				elemkind = fieldtype.Elem().Kind()
+			}
+			if elemkind != reflect.Int && elemkind != reflect.Float64 && elemkind != reflect.Float32 || unmarshaljson || converterFor(fieldtype, opts.converters) != nil {
+				return fmt.Errorf("field %v has a unit tag but is not an int or a float", structfield.Name)
+			}
+		}
+
+		separator := structfield.Tag.Get("separator")
+		if separator == "" {
+			separator = ","
+		}
+		filesep, hasfilesep := structfield.Tag.Lookup("filesep")
+		envsep, hasenvsep := structfield.Tag.Lookup("envsep")
+		if (hasfilesep || hasenvsep) && structfieldkind != reflect.Slice && structfieldkind != reflect.Map {
+			return fmt.Errorf("field %v has a filesep or envsep tag but is not a slice or a map", structfield.Name)
+		}
+
+		_, decimalcomma := structfield.Tag.Lookup("decimalcomma")
+		if decimalcomma {
+			elemkind := fieldtype.Kind()
+			if elemkind == reflect.Slice {
+				elemkind = fieldtype.Elem().Kind()
+			}
+			if elemkind != reflect.Float64 && elemkind != reflect.Float32 {
+				return fmt.Errorf("field %v has a decimalcomma tag but is not a float", structfield.Name)
+			}
+			if strings.Contains(strip, ",") {
+				return fmt.Errorf("field %v cannot have a decimalcomma tag and strip commas", structfield.Name)
+			}
+			if structfieldkind == reflect.Slice && strings.Contains(separator+filesep+envsep, ",") {
+				return fmt.Errorf("field %v has a decimalcomma tag but its elements are separated by commas", structfield.Name)
+			}
+		}
+
+		enum := opts.EnumMaps[structfield.Name]
+		if enum != nil {
+			elemtype := fieldtype
+			if structfieldkind == reflect.Slice {
+				elemtype = elemtype.Elem()
+			}
+			if elemtype.Kind() != reflect.Int || unmarshaljson || converterFor(fieldtype, opts.converters) != nil {
+				return fmt.Errorf("field %v has an entry in Options.EnumMaps but is not an int", structfield.Name)
+			}
+		}
+
+		_, loglevel := structfield.Tag.Lookup("loglevel")
+		if loglevel {
+			elemtype := fieldtype
+			if structfieldkind == reflect.Slice {
+				elemtype = elemtype.Elem()
+			}
+			if elemtype.Kind() != reflect.String || unmarshaljson || converterFor(fieldtype, opts.converters) != nil {
+				return fmt.Errorf("field %v has a loglevel tag but is not a string", structfield.Name)
+			}
+		}
+
+		_, count := structfield.Tag.Lookup("count")
+		if count && (structfieldkind != reflect.Int || fieldtype == durationType || unmarshaljson || enum != nil || unit != "" || converterFor(fieldtype, opts.converters) != nil) {
+			return fmt.Errorf("field %v has a count tag but is not an int", structfield.Name)
+		}
+
+		_, encrypted := structfield.Tag.Lookup("encrypted")
+		if encrypted && opts.Decryptor == nil {
+			return fmt.Errorf("field %v has an encrypted tag but Options.Decryptor is not set", structfield.Name)
+		}
+
+		envindexed := structfield.Tag.Get("envindexed")
+		if envindexed != "" && (structfieldkind != reflect.Slice || unmarshaljson) {
+			return fmt.Errorf("field %v has an envindexed tag but is not a slice", structfield.Name)
+		}
+		if envindexed != "" {
+			envindexed = opts.EnvPrefix + envindexed
+		}
+		var envjoin []string
+		if tag := structfield.Tag.Get("envjoin"); tag != "" {
+			for _, key := range strings.Split(tag, ",") {
+				envjoin = append(envjoin, opts.EnvPrefix+strings.TrimSpace(key))
+			}
+		}
+		if _, ok := structfield.Tag.Lookup("joinsep"); ok && envjoin == nil {
+			return fmt.Errorf("field %v has a joinsep tag but no envjoin tag", structfield.Name)
+		}
+
+		buildinfo := structfield.Tag.Get("buildinfo")
+		_, secret := structfield.Tag.Lookup("secret")
+
+		usage := structfield.Tag.Get("usage")
+		_, ismandatory := structfield.Tag.Lookup("mandatory")
+		mandatoryif := structfield.Tag.Get("mandatoryif")
+		if ismandatory && mandatoryif != "" {
+			return fmt.Errorf("field %v cannot have both a mandatory and a mandatoryif tag", structfield.Name)
+		}
+		deprecated := structfield.Tag.Get("deprecated")
+
+		relfile := structfield.Tag.Get("relfile")
+		filepathtag := structfield.Tag.Get("filepath")
+
+		keyring := structfield.Tag.Get("keyring")
+		var keyringservice, keyringaccount string
+		if keyring != "" {
+			i := strings.LastIndex(keyring, "/")
+			if i <= 0 || i == len(keyring)-1 {
+				return fmt.Errorf("field %v has a keyring tag which is not of the form service/account: %v", structfield.Name, keyring)
+			}
+			keyringservice, keyringaccount = keyring[:i], keyring[i+1:]
+		}
+
+		var sources []string
+		if tag, ok := structfield.Tag.Lookup("sources"); ok {
+			var err error
+			if sources, err = parseSources(tag); err != nil {
+				return fmt.Errorf("field %v has an invalid sources tag: %v", structfield.Name, err)
+			}
+			if _, noenv := structfield.Tag.Lookup("noenv"); noenv && hasSource(sources, sourceEnv) {
+				return fmt.Errorf("field %v has a sources tag which lists env but also has a noenv tag", structfield.Name)
+			}
+			if _, noflag := structfield.Tag.Lookup("noflag"); noflag && hasSource(sources, sourceFlag) {
+				return fmt.Errorf("field %v has a sources tag which lists flag but also has a noflag tag", structfield.Name)
+			}
+			if !hasSource(sources, sourceFile) {
+				filename = ""
+			}
+			if !hasSource(sources, sourceRelFile) {
+				relfile = ""
+			}
+			if !hasSource(sources, sourceFilePath) {
+				filepathtag = ""
+			}
+			if !hasSource(sources, sourceEnv) {
+				envkey = ""
+				envindexed = ""
+				envjoin = nil
+			}
+			if !hasSource(sources, sourceFlag) {
+				flagkey = ""
+			}
+			if !hasSource(sources, sourceKeyring) {
+				keyring, keyringservice, keyringaccount = "", "", ""
+			}
+		}
+
+		fromdocument := document != nil && !lazysecret && (sources == nil || hasSource(sources, sourceDocument))
+
+		// A mandatory field which cannot be set from any source can never be
+		// satisfied, so we treat it as a programming error.
+		if (ismandatory || mandatoryif != "") && filename == "" && relfile == "" && filepathtag == "" && envkey == "" && flagkey == "" && envindexed == "" && keyring == "" && envjoin == nil && buildinfo == "" && !fromdocument {
+			return fmt.Errorf("mandatory field %v has no source it can be set from - %s", structfield.Name, noSourceReason(sources))
+		}
+
+		legacyenvkey := ""
+		if envkey != "" && opts.LegacyEnvPrefix != "" {
+			legacyenvkey = envKeyFor(structfield, opts.LegacyEnvPrefix)
+		}
+
+		p := param{
+			name:             structfield.Name,
+			filename:         filename,
+			relFile:          relfile,
+			filePath:         filepathtag,
+			envKey:           envkey,
+			legacyEnvKey:     legacyenvkey,
+			envJoin:          envjoin,
+			joinSeparator:    structfield.Tag.Get("joinsep"),
+			buildInfo:        buildinfo,
+			keyringService:   keyringservice,
+			keyringAccount:   keyringaccount,
+			flagKey:          flagkey,
+			fieldKind:        structfieldkind,
+			fieldType:        fieldtype,
+			paramPointer:     parampointer,
+			fieldPointer:     fieldpointer,
+			mandatory:        ismandatory,
+			mandatoryIf:      mandatoryif,
+			fileExists:       fileexists,
+			extendedDuration: extendedduration,
+			clockDuration:    clockduration,
+			layout:           layout,
+			unixTime:         unixtime,
+			base:             base,
+			unmarshalJSON:    unmarshaljson,
+			converter:        converterFor(fieldtype, opts.converters),
+			converters:       opts.converters,
+			valueTransform:   opts.ValueTransform,
+			requirePrefix:    structfield.Tag.Get("requireprefix"),
+			encrypted:        encrypted,
+			decryptor:        opts.Decryptor,
+			format:           format,
+			strip:            strip,
+			decimalComma:     decimalcomma,
+			unit:             unit,
+			logLevel:         loglevel,
+			count:            count,
+			enum:             enum,
+			encodings:        encodings,
+			deprecated:       deprecated,
+			separator:        separator,
+			fileSeparator:    filesep,
+			envSeparator:     envsep,
+			envIndexed:       envindexed,
+			structElems:      structelems,
+			jsonStruct:       jsonstruct,
+			lazySecret:       lazysecret,
+			secret:           secret,
+			isSet:            false,
+		}
+		if err := p.parseConstraints(structfield.Tag); err != nil {
+			return err
+		}
+		params = append(params, &p)
+
+		p.defaultValue, p.hasDefault = structfield.Tag.Lookup("default")
+		if p.hasDefault {
+			var err error
+			if p.defaultValue, err = expandDefault(p.defaultValue, opts.lookupEnv, opts.ErrorOnUnsetDefaultVars); err != nil {
+				return fmt.Errorf("default value for field %s %v", p.name, err)
+			}
+		}
+		p.documentKey = documentKey(structfield)
+		p.documentValue, p.hasDocument = document[p.documentKey]
+		if sources != nil {
+			p.sources = sources
+			p.hasDefault = p.hasDefault && hasSource(sources, sourceDefault)
+			p.hasDocument = p.hasDocument && hasSource(sources, sourceDocument)
+		}
+		if lazysecret {
+			p.hasDocument = false
+		}
+		if p.hasDefault && !lazysecret {
+			// A default which can't be parsed, or doesn't satisfy the
+			// field's own constraints, is a programming error.
+			err := p.setParam(p.defaultValue, "default value", structfield.Name)
+			if err == nil {
+				err = p.validateValue()
+			}
+			if err != nil {
+				shown := p.defaultValue
+				if p.secret {
+					shown = redacted
+				}
+				return fmt.Errorf("default value %q for field %s is invalid: %v", shown, p.name, err)
+			}
+		}
+		if p.hasDocument {
+			if err := p.setParam(p.documentValue, "document key", p.documentKey); err != nil {
+				return err
+			}
+		}
+		if flagkey != "" {
+			opts.commandLine().Var(&p, flagkey, usage)
+			if opts.GenerateNegatedBoolFlags && p.IsBoolFlag() && !p.isSlice() && !p.count {
+				negated := negatedFlagPrefix + flagkey
+				if opts.commandLine().Lookup(negated) != nil {
+					return fmt.Errorf("flag -%s for field %s is already defined", negated, p.name)
+				}
+				opts.commandLine().Var(&negatedFlag{&p}, negated, "sets -"+flagkey+" to false")
+			}
+		}
+	}
+
+	if len(params) == 0 && opts.ErrorOnNoFields {
+		return ErrNoFields
+	}
+
+	byName := make(map[string]*param, len(params))
+	for _, p := range params {
+		byName[p.name] = p
+	}
+	for _, p := range params {
+		if p.mandatoryIf != "" && byName[p.mandatoryIf] == nil {
+			return fmt.Errorf("field %v has a mandatoryif tag which refers to an unknown field: %v", p.name, p.mandatoryIf)
+		}
+		if p.checksumField != "" {
+			if sum := byName[p.checksumField]; sum == nil || sum.fieldKind != reflect.String || sum.unmarshalJSON || sum.converter != nil {
+				return fmt.Errorf("field %v has a checksum tag which does not refer to a string field: %v", p.name, p.checksumField)
+			}
+		}
+		if p.equalTo != "" {
+			if other := byName[p.equalTo]; other == nil || other == p || other.fieldType != p.fieldType {
+				return fmt.Errorf("field %v has an equalto tag which does not refer to another field of the same type: %v", p.name, p.equalTo)
+			}
+		}
+	}
+	enummaps := make([]string, 0, len(opts.EnumMaps))
+	for name := range opts.EnumMaps {
+		enummaps = append(enummaps, name)
+	}
+	// Sorted so the same unknown field is reported on every run.
+	sort.Strings(enummaps)
+	for _, name := range enummaps {
+		if byName[name] == nil {
+			return fmt.Errorf("unknown field %v in Options.EnumMaps", name)
+		}
+	}
+
+	var dirflagval string
+	if pr.dirFlagKey != "" {
+		opts.commandLine().StringVar(&dirflagval, pr.dirFlagKey, pr.dir, "directory containing config files")
+	}
+
+	if metrics != nil {
+		start = time.Now()
+	}
+
+	if err := parseFlags(opts); err != nil {
+		return err
+	}
+
+	if metrics != nil {
+		metrics.FlagParse = time.Since(start)
+		start = time.Now()
+	}
+
+	var dir string
+	if pr.fsys != nil {
+		err := opts.bounded(func() error {
+			var err error
+			configFiles, err = scanFS(pr.fsys)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	} else if configFiles == nil && len(pr.dirs) > 0 {
+		dirs := make([]string, len(pr.dirs))
+		for i, d := range pr.dirs {
+			var err error
+			if dirs[i], err = resolveDir(d, opts); err != nil {
+				return err
+			}
+		}
+		err := opts.bounded(func() error {
+			var err error
+			configFiles, opts.dirIndexes, err = scanDirs(dirs)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	} else if configFiles == nil {
+		dir = pr.dir
+		if pr.dirFlagKey != "" {
+			dir = dirflagval
+		}
+		if pr.dirEnvKey != "" {
+			if envdir, _ := opts.lookupEnv(pr.dirEnvKey); envdir != "" {
+				dir = envdir
+			}
+		}
+		var err error
+		if dir, err = resolveDir(dir, opts); err != nil {
+			return err
+		}
+		if opts.Result != nil {
+			opts.Result.Dir = dir
+			if dir != "" {
+				info, err := os.Stat(dir)
+				opts.Result.DirExists = err == nil && info.IsDir()
+			}
+		}
+		if dir != "" {
+			err := opts.bounded(func() error {
+				var err error
+				configFiles, err = ScanDir(dir)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if metrics != nil {
+		metrics.DirScan = time.Since(start)
+		metrics.FilesScanned = len(configFiles)
+		start = time.Now()
+	}
+
+	defaultsdir, err := opts.defaultsDir()
+	if err != nil {
+		return err
+	}
+
+	// Loop through parameters a second time for the files and environment
+	// variables.
+	for _, p := range params {
+		if p.lazySecret {
+			p.setLazySecret(configFiles, dir, opts)
+			continue
+		}
+		if p.sources != nil {
+			if err := resolveSourceChain(p, configFiles, dir, opts); err != nil {
+				return err
+			}
+		} else if _, err := resolveSources(p, lookupSources, configFiles, dir, opts); err != nil {
+			return err
+		}
+		if p.envJoin != nil && (!p.isSet || p.source == "default value") {
+			if err := p.setFromEnvJoin(opts); err != nil {
+				return err
+			}
+		}
+		if p.buildInfo != "" && (!p.isSet || p.source == "default value") {
+			if err := p.setFromBuildInfo(opts); err != nil {
+				return err
+			}
+		}
+		if defaultsdir != "" && (!p.isSet || p.source == "default value") {
+			if err := p.setFromDefaultsDir(defaultsdir, opts); err != nil {
+				return err
+			}
+		}
+		if p.jsonStruct && p.envKey != "" {
+			if err := p.setSubFieldsFromEnv(opts); err != nil {
+				return err
+			}
+		}
+		if opts.FileEnvVars {
+			if err := checkFileEnvConflict(p, opts); err != nil {
+				return err
+			}
+		}
+	}
+
+	if opts.Result != nil && opts.dirIndexes != nil {
+		opts.Result.FieldDirs = make(map[string]int)
+		for _, p := range params {
+			if p.source == sourceFile && p.hasDirIndex {
+				opts.Result.FieldDirs[p.name] = p.dirIndex
+			}
+		}
+	}
+
+	if opts.DebugMultipleSources {
+		for _, p := range params {
+			if len(p.assignedFrom) > 1 {
+				opts.logf("debug: field %s was assigned from more than one source: %s", p.name, strings.Join(p.assignedFrom, ", then "))
+			}
+		}
+	}
+
+	if metrics != nil {
+		metrics.SourceLookup = time.Since(start)
+		for _, p := range params {
+			if p.isSet {
+				metrics.FieldsResolved++
+			}
+		}
+	}
+
+	if opts.PromptForMissing {
+		if err := promptForMissing(params, byName, opts); err != nil {
+			return err
+		}
+	}
+
+	// Check the resolved values against the constraints in their tags.
+	for _, p := range params {
+		if err := p.validate(); err != nil {
+			return err
+		}
+	}
+	if err := validateGroups(params); err != nil {
+		return err
+	}
+	for _, p := range params {
+		if p.checksumField != "" {
+			if err := p.validateChecksum(byName[p.checksumField]); err != nil {
+				return err
+			}
+		}
+		if p.equalTo != "" {
+			if err := p.validateEqualTo(byName[p.equalTo]); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Warn about deprecated fields which were explicitly set.
+	for _, p := range params {
+		if p.deprecated == "" || p.source == "" || p.source == "default value" {
+			continue
+		}
+		opts.logf("%s %s sets deprecated field %s: %s", p.source, p.sourceKey, p.name, p.deprecated)
+	}
+
+	// Loop through parameters again to pick up missing mandatory parameters.
+	// params is in field declaration order, so the messages are too.
+	var missing []string
+	for _, p := range params {
+		if !p.isMissing(byName) {
+			continue
+		}
+		missing = append(missing, p.name)
+		fmt.Fprintln(opts.commandLine().Output(), p.mandatoryMessage())
+	}
+
+	if len(missing) > 0 {
+		opts.usage()
+		return &MissingMandatoryError{Fields: missing}
+	}
+
+	return nil
+}
+
+// envKeyFor returns the name of the environment variable for structfield, or
+// an empty string if it has a noenv tag.
+func envKeyFor(structfield reflect.StructField, prefix string) string {
+	if _, noenv := structfield.Tag.Lookup("noenv"); noenv {
+		return ""
+	}
+	envkey := structfield.Tag.Get("env")
+	if len(envkey) == 0 {
+		envkey = strings.ToUpper(structfield.Name)
+	}
+	return prefix + envkey
+}
+
+// flagKeyFor returns the name of the command line flag for structfield, or an
+// empty string if it has a noflag tag.
+func flagKeyFor(structfield reflect.StructField) string {
+	if _, noflag := structfield.Tag.Lookup("noflag"); noflag {
+		return ""
+	}
+	flagkey := structfield.Tag.Get("flag")
+	if len(flagkey) == 0 {
+		flagkey = strings.ToLower(structfield.Name)
+	}
+	return flagkey
+}
+
+// unknownFlagPrefix is the start of the error returned by the flag package
+// for a flag which has not been defined.
+const unknownFlagPrefix = "flag provided but not defined: -"
+
+// parseFlags parses the command line flags. An unknown flag results in an
+// error which names it, or a warning if opts.UnknownFlagsAsWarnings is set.
+// Errors are only returned if the flag set was created with
+// flag.ContinueOnError - otherwise the flag package exits or panics first.
+func parseFlags(opts Options) error {
+	fs := opts.commandLine()
+	args := opts.commandArgs()
+	if opts.SpaceSeparatedBoolFlags {
+		args = joinBoolFlagValues(fs, args)
+	}
+	for {
+		err := parseFlagArgs(fs, args)
+		if err == nil {
+			return nil
+		}
+		if !strings.HasPrefix(err.Error(), unknownFlagPrefix) {
+			return err
+		}
+		name := strings.TrimPrefix(err.Error(), unknownFlagPrefix)
+		if !opts.UnknownFlagsAsWarnings {
+			return fmt.Errorf("unknown command line flag -%s: %w", name, err)
 		}
-		*(*bool)(p.paramPointer) = bval
-		return nil
+		opts.logf("ignoring unknown command line flag -%s", name)
+		// The flag package has already consumed the unknown flag, so carry
+		// on with the arguments which follow it.
+		args = fs.Args()
 	}
-
-	return fmt.Errorf("%s %s is of an unknown type: %v", configType, keyName, val)
 }
 
-func (p *param) Set(s string) error {
-	return p.setParam(s, "command line flag", p.flagKey)
+// joinBoolFlagValues returns args with each bool flag which is followed by a
+// true or false argument joined with it, so that -async true becomes
+// -async=true. Arguments after the flags, i.e. after -- or the first argument
+// which isn't a flag, are left alone.
+func joinBoolFlagValues(fs *flag.FlagSet, args []string) []string {
+	joined := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		joined = append(joined, arg)
+		if arg == "--" || len(arg) < 2 || arg[0] != '-' {
+			return append(joined, args[i+1:]...)
+		}
+		name := strings.TrimLeft(arg, "-")
+		if strings.Contains(name, "=") || i+1 == len(args) {
+			continue
+		}
+		f := fs.Lookup(name)
+		if f == nil {
+			continue
+		}
+		if bf, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !bf.IsBoolFlag() {
+			// The next argument is this flag's value.
+			i++
+			joined = append(joined, args[i])
+			continue
+		}
+		if next := args[i+1]; asciiEqualFold(next, "true") || asciiEqualFold(next, "false") {
+			joined[len(joined)-1] = arg + "=" + next
+			i++
+		}
+	}
+	return joined
 }
 
-func (p param) IsBoolFlag() bool {
-	return p.fieldKind == reflect.Bool
+// parseFlagArgs parses args with fs. Nothing is printed for an unknown flag,
+// as long as the error can be returned to the caller.
+func parseFlagArgs(fs *flag.FlagSet, args []string) error {
+	if fs.ErrorHandling() != flag.ContinueOnError {
+		return fs.Parse(args)
+	}
+	out := fs.Output()
+	var buf strings.Builder
+	fs.SetOutput(&buf)
+	err := fs.Parse(args)
+	fs.SetOutput(out)
+	if err != nil && !strings.HasPrefix(err.Error(), unknownFlagPrefix) {
+		io.WriteString(out, buf.String())
+	}
+	return err
 }
 
-// Parse will take in a pointer to a struct and set each field to an
-// environment variable or a flag from the command line. The environment
-// variable will take precedence over the command line flag.
-//
-// Parse will invoke ParseWithDir with dir set to an empty string.
-//
-func Parse(ptrtostruct interface{}) error {
-	return ParseWithDir(ptrtostruct, "")
+// readFile returns the contents of the file at path in fsys, or on disk if
+// fsys is nil.
+func readFile(fsys fs.FS, path string) (string, error) {
+	if fsys == nil {
+		return getFileContents(path)
+	}
+	b, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
 }
 
-// ParseWithDir will take in a pointer to a struct and set each field to a
-// value in the a file, environment variable, or a flag from the command line.
-// The file will take precedence over the environment variable and the
-// environment variable will take precedence over the command line flag.
-//
-// If a field is of type bool, it will be set to true as long as the
-// corresponding environment variable is set, irrespective of the environment
-// variable's value.
-//
-// Set the appropriate tag in each field to tell ParseWithDir how to handle the
-// field. ParseWithDir accepts the following tags: env, flag, default, usage,
-// mandatory.
-//
-// The env tag specifies the environment variable name which corresponds to
-// the field. If this is not specified, ParseWithDir uses the uppercase version
-// of the field name.
-//
-// The flag tag specifies the command line flag name which corresponds to the
-// field. If this is not specified, ParseWithDir uses the lowercase version of
-// the field name.
-//
-// The default tag specifies a default value for the field. This value is used
-// if the corresponding environment variable and command line flag do not
-// exist.
-//
-// The mandatory tag marks the field as mandatory. If the corresponding
-// environment variable and command line flag do not exist, ParseWithDir will
-// print an error message and the usage to stderr and return with an error.
-// ParseWithDir will assume that the field is mandatory as long as the tag
-// exists - it doesn't matter what value the tag is set to.
-//
-// The usage tag specifies the usage text for the command line flag.
-//
-func ParseWithDir(ptrtostruct interface{}, dir string) error {
-	ptrtostructval := reflect.ValueOf(ptrtostruct)
-	if ptrtostructval.Kind() != reflect.Ptr {
-		return fmt.Errorf("argument must be a pointer to struct - got %v instead", ptrtostructval.Kind())
+func getFileContents(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return "", err
 	}
+	return string(b), nil
+}
 
-	structval := ptrtostructval.Elem()
-	if structval.Kind() != reflect.Struct {
-		return fmt.Errorf("argument must be a pointer to struct - got a pointer to %v instead", structval.Kind())
+// setParamFromFile sets p to the contents of the file at path in fsys, or on
+// disk if fsys is nil, which is identified as key in error messages. found is
+// false if the file does not exist, so that the caller can fall through to
+// other sources.
+func setParamFromFile(p *param, fsys fs.FS, path, key string, opts Options) (found bool, err error) {
+	var filecontents string
+	err = opts.bounded(func() error {
+		var err error
+		filecontents, err = readFile(fsys, path)
+		return err
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			// file does not exist, fall through and check if it's set as
+			// an environment variable
+			return false, nil
+		}
+		// error is not file not found - i.e. the file exists and the error
+		// is something else
+		return false, err
 	}
+	return setParamFromContents(p, p.trimFileContents(filecontents, opts), key, opts)
+}
 
-	configFiles := allFilesInDirectory(dir)
+// trimFileContents removes a single trailing newline, \n or \r\n, from
+// contents, which were read from the field's file, or all trailing whitespace
+// if Options.TrimFileWhitespace is set. The contents of a file for a field
+// with an encoding tag are left alone.
+func (p param) trimFileContents(contents string, opts Options) string {
+	if len(p.encodings) > 0 {
+		return contents
+	}
+	if opts.TrimFileWhitespace {
+		return strings.TrimRightFunc(contents, unicode.IsSpace)
+	}
+	if strings.HasSuffix(contents, "\r\n") {
+		return contents[:len(contents)-2]
+	}
+	return strings.TrimSuffix(contents, "\n")
+}
 
-	params = []*param{}
-	structtype := structval.Type()
-	fieldcount := structtype.NumField()
+// setParamFromFilePath sets p from the file or file descriptor in its
+// filepath tag. found is false if the file does not exist.
+func setParamFromFilePath(p *param, opts Options) (found bool, err error) {
+	if !strings.HasPrefix(p.filePath, "fd:") {
+		return setParamFromFile(p, nil, p.filePath, p.filePath, opts)
+	}
 
-	// We'll loop through the parameters twice - once for the command line
-	// flags, and another for the files and environment variables. This is
-	// because the files and environment variables take precedence over
-	// command line flags.
-	for i := 0; i < fieldcount; i++ {
-		structfield := structtype.FieldByIndex([]int{i})
-		structfieldkind := structfield.Type.Kind()
+	fd, err := strconv.ParseUint(strings.TrimPrefix(p.filePath, "fd:"), 10, 0)
+	if err != nil {
+		return false, fmt.Errorf("field %s has an invalid file descriptor in its filepath tag: %v", p.name, p.filePath)
+	}
+	f := os.NewFile(uintptr(fd), p.filePath)
+	if f == nil {
+		return false, fmt.Errorf("file descriptor %d for field %s is not valid", fd, p.name)
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return false, fmt.Errorf("file descriptor %d for field %s could not be read: %v", fd, p.name, err)
+	}
+	return setParamFromContents(p, p.trimFileContents(string(b), opts), p.filePath, opts)
+}
 
-		// We only support fields of type string, int, and bool.
-		if structfieldkind != reflect.String && structfieldkind != reflect.Int && structfieldkind != reflect.Bool {
-			log.Printf("skipping field %v because it is not of a supported type", structfield.Name)
-			continue
+// setParamFromContents sets p to filecontents, the contents of the file
+// identified as key, after decoding them and stripping any inline comment.
+// found is false if the contents are blank and Options.BlankFileAsUnset is
+// set, in which case p is left alone.
+func setParamFromContents(p *param, filecontents, key string, opts Options) (found bool, err error) {
+	if opts.BlankFileAsUnset && strings.TrimSpace(filecontents) == "" {
+		return false, nil
+	}
+	if len(p.encodings) > 0 && !isByteArrayType(p.fieldType) {
+		decoded, err := decodeContents([]byte(filecontents), p.encodings)
+		if err != nil {
+			return false, fmt.Errorf("file %s for field %s could not be decoded: %v", key, p.name, err)
+		}
+		filecontents = string(decoded)
+	}
+	if opts.StripInlineComments {
+		filecontents = stripInlineComment(filecontents)
+	}
+	if err := p.setParam(filecontents, "file", key); err != nil {
+		return false, err
+	}
+	// no errors setting param to file contents - report the environment
+	// variable if it disagrees with the file
+	if opts.OnConflict != nil && p.envKey != "" {
+		if _, envval, ok := p.lookupEnv(opts); ok && envval != filecontents {
+			opts.OnConflict(p.name, filecontents, envval)
 		}
+	}
+	return true, nil
+}
 
-		// Skip invalid fields and fields that cannot be set.
-		field := structval.FieldByIndex([]int{i})
-		if !field.IsValid() || !field.CanSet() {
-			log.Printf("skipping field %v because it is not valid or cannot be set", structfield.Name)
+// setParamFromConfigFiles sets p from the first of its candidate files which
+// exists in configFiles or, for candidates which are nested paths, in dir. It
+// returns false if none of them exist.
+func setParamFromConfigFiles(p *param, configFiles map[string]string, dir string, opts Options) (found bool, err error) {
+	for _, name := range withEnvSuffix(p.fileCandidates(), opts.EnvSuffix) {
+		if isGlob(name) {
+			found, err := setParamFromGlob(p, configFiles, dir, name, opts)
+			if err != nil || found {
+				return found, err
+			}
 			continue
 		}
-
-		// Skip field if this field cannot be converted to a pointer (necessary
-		// for flag call).
-		if !field.CanAddr() {
-			log.Printf("skipping field %v because it cannot be converted to a pointer", structfield.Name)
+		configFilePath, ok := lookupConfigFile(configFiles, dir, name, opts.fsys)
+		if !ok {
 			continue
 		}
-
-		filename := structfield.Tag.Get("file")
-		if dir != "" {
-			if filename == "" {
-				filename = strings.ToLower(structfield.Name)
+		if p.fileExists {
+			p.setParam("true", "file", name)
+			p.setDirIndex([]string{configFilePath}, opts)
+			return true, nil
+		}
+		found, err := setParamFromFile(p, opts.fsys, configFilePath, name, opts)
+		if err != nil || found {
+			if found {
+				p.setDirIndex([]string{configFilePath}, opts)
 			}
-		} else {
-			filename = ""
+			return found, err
 		}
+	}
+	return false, nil
+}
 
-		envkey := structfield.Tag.Get("env")
-		if len(envkey) == 0 {
-			envkey = strings.ToUpper(structfield.Name)
+// setDirIndex records the index of the config directory containing the files
+// at paths, which the field was just set from. If the files are in several
+// directories, the one with the highest precedence is recorded. Nothing is
+// recorded unless the parser has several directories.
+func (p *param) setDirIndex(paths []string, opts Options) {
+	p.hasDirIndex = false
+	for _, path := range paths {
+		if i, ok := opts.dirIndexes[path]; ok && (!p.hasDirIndex || i > p.dirIndex) {
+			p.dirIndex = i
+			p.hasDirIndex = true
 		}
-		flagkey := structfield.Tag.Get("flag")
-		if len(flagkey) == 0 {
-			flagkey = strings.ToLower(structfield.Name)
+	}
+}
+
+// withEnvSuffix returns names with each name which isn't a pattern preceded
+// by the same name with suffix appended after a dot, e.g. port.production
+// before port, so that the suffixed file is preferred. names is returned
+// unchanged if suffix is empty.
+func withEnvSuffix(names []string, suffix string) []string {
+	if suffix == "" {
+		return names
+	}
+	suffixed := make([]string, 0, 2*len(names))
+	for _, name := range names {
+		if !isGlob(name) {
+			suffixed = append(suffixed, name+"."+suffix)
 		}
+		suffixed = append(suffixed, name)
+	}
+	return suffixed
+}
 
-		usage := structfield.Tag.Get("usage")
-		_, ismandatory := structfield.Tag.Lookup("mandatory")
+// isGlob returns true if name contains any of the special characters used by
+// path.Match.
+func isGlob(name string) bool {
+	return strings.ContainsAny(name, `*?[\`)
+}
 
-		p := param{
-			filename:     filename,
-			envKey:       envkey,
-			flagKey:      flagkey,
-			fieldKind:    structfieldkind,
-			paramPointer: unsafe.Pointer(field.Addr().Pointer()),
-			mandatory:    ismandatory,
-			isSet:        false,
+// setParamFromGlob sets p to the concatenated contents of the config files
+// matching pattern, in lexical order of their names. found is false if no
+// files match.
+func setParamFromGlob(p *param, configFiles map[string]string, dir, pattern string, opts Options) (found bool, err error) {
+	paths, err := globConfigFiles(configFiles, dir, pattern, opts.fsys)
+	if err != nil {
+		return false, fmt.Errorf("field %s has an invalid file pattern %s: %v", p.name, pattern, err)
+	}
+	if len(paths) == 0 {
+		return false, nil
+	}
+	p.setDirIndex(paths, opts)
+	if p.fileExists {
+		p.setParam("true", "file", pattern)
+		return true, nil
+	}
+	var contents strings.Builder
+	for _, path := range paths {
+		var filecontents string
+		err := opts.bounded(func() error {
+			var err error
+			filecontents, err = readFile(opts.fsys, path)
+			return err
+		})
+		if err != nil {
+			return false, err
 		}
-		params = append(params, &p)
+		contents.WriteString(filecontents)
+	}
+	return setParamFromContents(p, contents.String(), pattern, opts)
+}
 
-		if defaultval, defaultexists := structfield.Tag.Lookup("default"); defaultexists {
-			p.Set(defaultval)
+// globConfigFiles returns the paths of the config files matching pattern,
+// sorted lexically by name. As with lookupConfigFile, a pattern containing a
+// forward slash is matched against paths relative to the config directory,
+// or to the root of fsys if it is not nil.
+func globConfigFiles(configFiles map[string]string, dir, pattern string, fsys fs.FS) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	if strings.Contains(pattern, "/") && fsys != nil {
+		paths, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return nil, err
 		}
-		flag.Var(&p, flagkey, usage)
+		sort.Strings(paths)
+		return regularFiles(paths, func(path string) (fs.FileInfo, error) { return fs.Stat(fsys, path) }), nil
 	}
-
-	flag.Parse()
-
-	// Loop through parameters a second time for the files and environment
-	// variables.
-	for _, p := range params {
-		if p.filename != "" {
-			configFilePath, ok := configFiles[p.filename]
-			if ok {
-				filecontents, err := getFileContents(configFilePath)
-				if err == nil {
-					err := p.setParam(filecontents, "file", p.filename)
-					if err != nil {
-						return err
-					}
-					// no errors setting param to file contents
-					continue
-				} else {
-					if !os.IsNotExist(err) {
-						// error is not file not found - i.e. the file exists
-						// and the error is something else
-						return err
-					}
-					// file does not exist, fall through and check if it's set as
-					// an environment variable
-				}
-			}
+	if strings.Contains(pattern, "/") && dir != "" {
+		// filepath.Glob sorts its results.
+		paths, err := filepath.Glob(filepath.Join(dir, filepath.FromSlash(pattern)))
+		if err != nil {
+			return nil, err
+		}
+		return regularFiles(paths, os.Stat), nil
+	}
+	var names []string
+	for name := range configFiles {
+		if ok, _ := path.Match(pattern, filepath.ToSlash(name)); ok {
+			names = append(names, name)
 		}
+	}
+	sort.Strings(names)
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = configFiles[name]
+	}
+	return paths, nil
+}
 
-		envval, envkeyexists := os.LookupEnv(p.envKey)
-		if !envkeyexists {
-			continue
+// regularFiles returns the paths which stat reports are regular files, so
+// that directories matched by a pattern are skipped.
+func regularFiles(paths []string, stat func(string) (fs.FileInfo, error)) []string {
+	files := paths[:0]
+	for _, path := range paths {
+		if info, err := stat(path); err == nil && info.Mode().IsRegular() {
+			files = append(files, path)
 		}
+	}
+	return files
+}
 
-		if err := p.setParam(envval, "environment variable", p.envKey); err != nil {
-			return err
+// lookupConfigFile returns the path of the config file called name. A name
+// containing a forward slash, e.g. sub/key, is a path relative to the config
+// directory, and is matched regardless of the operating system's path
+// separator - in fsys if it is not nil, in dir if it is set, and otherwise
+// against the keys of configFiles.
+func lookupConfigFile(configFiles map[string]string, dir, name string, fsys fs.FS) (string, bool) {
+	if path, ok := configFiles[name]; ok {
+		return path, true
+	}
+	if !strings.Contains(name, "/") {
+		return "", false
+	}
+	if fsys != nil {
+		if info, err := fs.Stat(fsys, name); err == nil && info.Mode().IsRegular() {
+			return name, true
+		}
+		return "", false
+	}
+	if dir != "" {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			return path, true
+		}
+		return "", false
+	}
+	for key, path := range configFiles {
+		if filepath.ToSlash(key) == name {
+			return path, true
 		}
 	}
+	return "", false
+}
 
-	// Loop through parameters again to pick up missing mandatory parameters.
-	missingCount := 0
-	for _, p := range params {
-		if !p.mandatory || p.isSet {
-			continue
+// indexedEnv returns the values of the environment variables prefix_0,
+// prefix_1 and so on, stopping at the first one which is not set.
+func indexedEnv(prefix string, opts Options) []string {
+	var vals []string
+	for i := 0; ; i++ {
+		val, ok := opts.lookupEnv(fmt.Sprintf("%s_%d", prefix, i))
+		if !ok {
+			return vals
 		}
-		missingCount++
-		fmt.Fprintf(flag.CommandLine.Output(), "Mandatory flag -%s (or environment variable %s) does not exist.\n", p.flagKey, p.envKey)
+		vals = append(vals, val)
 	}
+}
 
-	params = []*param{}
-	if missingCount > 0 {
-		flag.Usage()
-		return fmt.Errorf("%d mandatory parameters missing", missingCount)
+// setStructElems sets a slice of structs from the environment variables
+// prefix_0_KEY, prefix_1_KEY and so on, where KEY is the env tag or the
+// uppercase name of each of the struct's fields. It stops at the first index
+// for which no variable starting with prefix_N_ is set, and returns false if
+// there are no elements at all.
+func (p *param) setStructElems(opts Options) (bool, error) {
+	environ := opts.environ()
+	elemtype := p.fieldType.Elem()
+	slice := reflect.MakeSlice(p.fieldType, 0, 0)
+	for i := 0; ; i++ {
+		prefix := fmt.Sprintf("%s_%d_", p.envIndexed, i)
+		if !hasEnvPrefix(environ, prefix) {
+			break
+		}
+		elem := reflect.New(elemtype).Elem()
+		for j := 0; j < elemtype.NumField(); j++ {
+			structfield := elemtype.Field(j)
+			fp := valueParam(structfield, elem.Field(j), p.converters)
+			if fp == nil {
+				continue
+			}
+			if defaultval, ok := structfield.Tag.Lookup("default"); ok {
+				if err := fp.setValue(defaultval, "default value", structfield.Name); err != nil {
+					return false, fmt.Errorf("element %d of %v", i, err)
+				}
+			}
+			key := structfield.Tag.Get("env")
+			if key == "" {
+				key = strings.ToUpper(structfield.Name)
+			}
+			if val, ok := opts.lookupEnv(prefix + key); ok {
+				val, err := p.transformValue(val)
+				if err != nil {
+					return false, err
+				}
+				if err := fp.setValue(val, "environment variable", prefix+key); err != nil {
+					return false, fmt.Errorf("element %d of %v", i, err)
+				}
+			}
+		}
+		slice = reflect.Append(slice, elem)
+	}
+	if slice.Len() == 0 {
+		return false, nil
 	}
+	reflect.NewAt(p.fieldType, p.paramPointer).Elem().Set(slice)
+	p.assignedFrom = append(p.assignedFrom, "environment variables "+p.envIndexed+"_*")
+	p.isSet = true
+	p.source = "environment variables"
+	p.sourceKey = p.envIndexed + "_*"
+	return true, nil
+}
 
-	return nil
+// hasEnvPrefix returns true if any of the variables in environ, in the format
+// returned by os.Environ, has a name starting with prefix.
+func hasEnvPrefix(environ []string, prefix string) bool {
+	for _, kv := range environ {
+		if strings.HasPrefix(kv, prefix) && strings.Contains(kv[len(prefix):], "=") {
+			return true
+		}
+	}
+	return false
 }
 
-func getFileContents(filename string) (string, error) {
-	f, err := os.Open(filename)
-	if err != nil {
-		return "", err
+// stripInlineComment removes everything from the first # on the first line
+// of s, along with any whitespace preceding the #.
+func stripInlineComment(s string) string {
+	firstline, rest := s, ""
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		firstline, rest = s[:i], s[i:]
 	}
-	defer f.Close()
-	b, err := io.ReadAll(f)
-	if err != nil {
-		return "", err
+	i := strings.IndexByte(firstline, '#')
+	if i < 0 {
+		return s
 	}
-	return string(b), nil
+	return strings.TrimRight(firstline[:i], " \t") + rest
 }
 
-func allFilesInDirectory(dir string) map[string]string {
+// ScanDir walks dir and its subdirectories, and returns a map of file names
+// to file paths for every regular file found. If two files in different
+// subdirectories have the same name, only one of them is kept. The map can be
+// passed to ParseWithFileMap. An empty dir results in an empty map.
+func ScanDir(dir string) (map[string]string, error) {
 	files := make(map[string]string)
 
 	if dir == "" {
-		return files
+		return files, nil
 	}
 
 	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
 		if !entry.Type().IsRegular() {
 			return nil
 		}
@@ -292,23 +2825,136 @@ func allFilesInDirectory(dir string) map[string]string {
 	})
 
 	if err != nil {
-		log.Fatalf("error traversing config directory %s: %v", dir, err)
+		return nil, fmt.Errorf("error traversing config directory %s: %v", dir, err)
 	}
 
-	return files
+	return files, nil
+}
+
+// scanDirs walks each of dirs in the same way as ScanDir, and returns a map of
+// file names to their paths, in which a file replaces any file of the same
+// name in an earlier directory. The paths of files relative to their
+// directory, e.g. tls/key, are included as well, so that file tags containing
+// forward slashes can be found in any of the directories. indexes maps each
+// path to the index of its directory in dirs.
+func scanDirs(dirs []string) (files map[string]string, indexes map[string]int, err error) {
+	files = make(map[string]string)
+	indexes = make(map[string]int)
+	for i, dir := range dirs {
+		dirfiles, err := ScanDir(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Sort the paths so that a name which occurs more than once in
+		// the same directory always resolves to the same file.
+		paths := make([]string, 0, len(dirfiles))
+		for _, path := range dirfiles {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			files[filepath.Base(path)] = path
+			if rel, err := filepath.Rel(dir, path); err == nil {
+				files[filepath.ToSlash(rel)] = path
+			}
+			indexes[path] = i
+		}
+	}
+	return files, indexes, nil
+}
+
+// scanProfile returns the files used by ParseWithProfile. The files directly
+// in dir are found by name, and every file below dir by its path relative to
+// dir. The files in the profile's subdirectory of dir are then added in the
+// same way as scanDirs adds a directory, replacing those in dir.
+func scanProfile(dir, profile string) (map[string]string, error) {
+	files := make(map[string]string)
+	if dir == "" {
+		return files, nil
+	}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		// A file directly in dir has no slash in its relative path, so it
+		// is found by name.
+		files[filepath.ToSlash(rel)] = path
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error traversing config directory %s: %v", dir, err)
+	}
+	if profile == "" {
+		return files, nil
+	}
+	profiledir := filepath.Join(dir, profile)
+	if info, err := os.Stat(profiledir); err != nil || !info.IsDir() {
+		return files, nil
+	}
+	profilefiles, _, err := scanDirs([]string{profiledir})
+	if err != nil {
+		return nil, err
+	}
+	for name, path := range profilefiles {
+		files[name] = path
+	}
+	return files, nil
+}
+
+// resolveDir returns dir, made relative to the directory containing the
+// running executable if opts.ResolveDirRelativeToExe is set and dir is
+// relative.
+func resolveDir(dir string, opts Options) (string, error) {
+	if !opts.ResolveDirRelativeToExe || dir == "" || filepath.IsAbs(dir) {
+		return dir, nil
+	}
+	exe, err := executable()
+	if err != nil {
+		return "", fmt.Errorf("could not resolve config directory %s relative to the executable: %v", dir, err)
+	}
+	return filepath.Join(filepath.Dir(exe), dir), nil
+}
+
+// scanFS walks fsys in the same way as ScanDir walks a config directory, and
+// returns a map of file names to their paths in fsys.
+func scanFS(fsys fs.FS) (map[string]string, error) {
+	files := make(map[string]string)
+	err := fs.WalkDir(fsys, ".", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		files[entry.Name()] = path
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error traversing config files: %v", err)
+	}
+	return files, nil
 }
 
 // Retrieves file config directory from an environment variable or command
 // line flag. The environment variable takes precedence.
 // This function is only used to retrieve the configuration directory name.
+//
+// Because the flag is parsed separately from the struct's flags, it does not
+// show up in the usage text. Parser.WithConfigDirectory avoids this.
 func RetrieveConfigDirectory(envKey, flagKey, defaultval string) string {
 	var val string
 	if len(envKey) > 0 {
 		val = os.Getenv(envKey)
-		if len(val) == 0 {
-			return defaultval
+		if len(val) > 0 {
+			return val
 		}
-		return val
 	}
 
 	if len(flagKey) > 0 {
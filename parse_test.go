@@ -2,12 +2,22 @@ package configparser
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 type configFile struct {
@@ -111,6 +121,51 @@ func TestBasic(t *testing.T) {
 	// Needed because we are calling flag.Parse() each time we run a test.
 	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
 }
+
+func TestBoolValues(t *testing.T) {
+	type Config struct {
+		Async bool
+	}
+
+	tables := []struct {
+		env      string
+		expected bool
+	}{
+		{"true", true},
+		{"TRUE", true},
+		{"True", true},
+		{"FALSE", false},
+		{"False", false},
+		{"No", false},
+		{"F", false},
+		{"0", false},
+		{"FAL\u017fE", true}, // only ASCII letters are folded
+		{"\u0130", true},     // Turkish dotted capital I
+	}
+
+	defer os.Unsetenv("ASYNC")
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags([]string{})
+		os.Setenv("ASYNC", table.env)
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		result := Config{}
+		if err := Parse(&result); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result.Async != table.expected {
+			t.Errorf("Expected %q to parse as %v but got %v instead", table.env, table.expected, result.Async)
+		}
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
 func TestMandatory(t *testing.T) {
 	type User struct {
 		Name    string `mandatory:"true"`
@@ -187,6 +242,192 @@ func TestMandatory(t *testing.T) {
 	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
 }
 
+func TestMandatoryIf(t *testing.T) {
+	type Config struct {
+		TLSEnabled bool
+		TLSCert    string `mandatoryif:"TLSEnabled"`
+	}
+
+	tables := []struct {
+		flags    []string
+		expected Config
+		isErr    bool
+	}{
+		{[]string{}, Config{false, ""}, false},
+		{[]string{"-tlscert", "cert.pem"}, Config{false, "cert.pem"}, false},
+		{[]string{"-tlsenabled", "-tlscert", "cert.pem"}, Config{true, "cert.pem"}, false},
+		{[]string{"-tlsenabled"}, Config{}, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		stderr := new(bytes.Buffer)
+		flag.CommandLine.SetOutput(stderr)
+
+		result := Config{}
+		err := Parse(&result)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+
+	// The controlling field must exist.
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	invalid := struct {
+		TLSCert string `mandatoryif:"TLSOn"`
+	}{}
+	if err := Parse(&invalid); err == nil {
+		t.Error("Expected an error for a mandatoryif tag referring to an unknown field but did not get it")
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestMandatoryNoSource(t *testing.T) {
+	config := struct {
+		Token string `mandatory:"true" noflag:"true" noenv:"true"`
+	}{}
+
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	stderr := new(bytes.Buffer)
+	flag.CommandLine.SetOutput(stderr)
+
+	err := Parse(&config)
+	if err == nil {
+		t.Error("Expected an error for a mandatory field with no sources but did not get it")
+	} else {
+		t.Logf("Expected an error - got: %v", err)
+	}
+
+	// With a config directory the file is a viable source, so the field is
+	// only reported as missing.
+	dir, err := createFilesInTempDir(map[string]configFile{})
+	if err != nil {
+		t.Errorf("Could not create files in temp dir: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	stderr.Reset()
+	flag.CommandLine.SetOutput(stderr)
+
+	err = ParseWithDir(&config, dir)
+	if err == nil {
+		t.Error("Expected a missing mandatory parameter error but did not get it")
+	}
+	if !strings.Contains(stderr.String(), "Mandatory file token does not exist.") {
+		t.Errorf("Expected stderr to mention the missing file but got: %v", stderr.String())
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestMandatoryOrder(t *testing.T) {
+	config := struct {
+		Zeta  string `mandatory:"true"`
+		Alpha string `mandatory:"true"`
+		Mid   string `mandatory:"true"`
+	}{}
+
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	stderr := new(bytes.Buffer)
+	flag.CommandLine.SetOutput(stderr)
+
+	if err := Parse(&config); err == nil {
+		t.Error("Expected a missing mandatory parameter error but did not get it")
+	}
+
+	expected := []string{
+		"Mandatory flag -zeta (or environment variable ZETA) does not exist.",
+		"Mandatory flag -alpha (or environment variable ALPHA) does not exist.",
+		"Mandatory flag -mid (or environment variable MID) does not exist.",
+	}
+	lines := strings.Split(stderr.String(), "\n")
+	if len(lines) < len(expected) {
+		t.Fatalf("Expected at least %d lines but got: %v", len(expected), stderr.String())
+	}
+	for i, line := range expected {
+		if lines[i] != line {
+			t.Errorf("Expected line %d to be %q but got %q instead", i, line, lines[i])
+		}
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestMissingMandatoryPartialResult(t *testing.T) {
+	type Config struct {
+		Host     string `default:"localhost"`
+		Port     int
+		Password string `mandatory:"true"`
+		Token    string `mandatory:"true"`
+	}
+
+	pr := deterministicParser("", map[string]string{"PORT": "8080"}, nil, Options{})
+	pr.opts.flagSet.SetOutput(new(bytes.Buffer))
+
+	result := Config{}
+	err := pr.Parse(&result)
+	var missing *MissingMandatoryError
+	if !errors.As(err, &missing) {
+		t.Fatalf("Expected a MissingMandatoryError but got: %v", err)
+	}
+	if expected := []string{"Password", "Token"}; !reflect.DeepEqual(missing.Fields, expected) {
+		t.Errorf("Expected missing fields %v but got %v instead", expected, missing.Fields)
+	}
+	if err.Error() != "2 mandatory parameters missing" {
+		t.Errorf("Unexpected error message: %v", err)
+	}
+	if expected := (Config{Host: "localhost", Port: 8080}); result != expected {
+		t.Errorf("Expected %+v but got %+v instead", expected, result)
+	}
+}
+
+func TestNonPointer(t *testing.T) {
+	type Config struct {
+		Port int
+	}
+
+	err := Parse(Config{})
+	if err == nil {
+		t.Fatal("Expected an error for a struct passed by value but did not get it")
+	}
+	if !strings.Contains(err.Error(), "pass &yourStruct") {
+		t.Errorf("Expected the error to suggest passing a pointer but got: %v", err)
+	}
+
+	err = Parse(new(int))
+	if err == nil {
+		t.Fatal("Expected an error for a pointer to an int but did not get it")
+	}
+	if strings.Contains(err.Error(), "pass &yourStruct") {
+		t.Errorf("Did not expect the error to suggest passing a pointer but got: %v", err)
+	}
+}
+
 func TestFilesSimple(t *testing.T) {
 	filevalues := make(map[string]configFile)
 	filevalues["username"] = configFile{
@@ -256,6 +497,46 @@ func TestFilesSimple(t *testing.T) {
 	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
 }
 
+func TestFileCandidates(t *testing.T) {
+	filevalues := map[string]configFile{
+		"config":       {contents: "shared"},
+		"local.secret": {contents: "local"},
+		"secret":       {contents: "shared"},
+	}
+
+	dir, err := createFilesInTempDir(filevalues)
+	if err != nil {
+		t.Errorf("Could not create files in temp dir: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	config := struct {
+		Config  string `file:"config.local,config"`
+		Secret  string `file:"local.secret, secret"`
+		Missing string `file:"a,b"`
+	}{}
+
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	if err := ParseWithDir(&config, dir); err != nil {
+		t.Errorf("Unexpected error while parsing config directory: %v", err)
+		return
+	}
+	if config.Config != "shared" {
+		t.Errorf("Expected the second candidate to be used when the first is absent but got %q", config.Config)
+	}
+	if config.Secret != "local" {
+		t.Errorf("Expected the first candidate to be used but got %q", config.Secret)
+	}
+	if config.Missing != "" {
+		t.Errorf("Expected no value when no candidate exists but got %q", config.Missing)
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
 func TestFilesNestedDirectories(t *testing.T) {
 	filevalues := make(map[string]configFile)
 	filevalues["username"] = configFile{
@@ -325,6 +606,2981 @@ func TestFilesNestedDirectories(t *testing.T) {
 	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
 }
 
+func TestFileNestedPaths(t *testing.T) {
+	filevalues := map[string]configFile{
+		"key":  {subDirs: "primary", contents: "primary-key"},
+		"cert": {subDirs: filepath.Join("secondary", "tls"), contents: "secondary-cert"},
+	}
+	dir, err := createFilesInTempDir(filevalues)
+	if err != nil {
+		t.Fatalf("Could not create files in temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.WriteFile(filepath.Join(dir, "secondary", "key"), []byte("secondary-key"), 0644); err != nil {
+		t.Fatalf("Could not create file: %v", err)
+	}
+
+	type Config struct {
+		PrimaryKey    string `file:"primary/key"`
+		SecondaryKey  string `file:"secondary/key"`
+		SecondaryCert string `file:"secondary/tls/cert"`
+		Missing       string `file:"primary/cert"`
+	}
+
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	config := Config{}
+	if err := ParseWithDir(&config, dir); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := Config{"primary-key", "secondary-key", "secondary-cert", ""}
+	if config != expected {
+		t.Errorf("Expected %+v but got %+v instead", expected, config)
+	}
+
+	// A file map built with the operating system's separator matches the
+	// forward-slash tag too.
+	fileMap := map[string]string{
+		filepath.Join("secondary", "tls", "cert"): filepath.Join(dir, "secondary", "tls", "cert"),
+	}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	config = Config{}
+	if err := ParseWithFileMap(&config, fileMap); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.SecondaryCert != "secondary-cert" {
+		t.Errorf("Expected secondary-cert but got %q instead", config.SecondaryCert)
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestFileGlob(t *testing.T) {
+	filevalues := map[string]configFile{
+		"ca-2":  {subDirs: "certs", contents: "second\n"},
+		"ca-1":  {contents: "first\n"},
+		"other": {contents: "ignored\n"},
+	}
+	dir, err := createFilesInTempDir(filevalues)
+	if err != nil {
+		t.Fatalf("Could not create files in temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	// A directory matching a pattern is skipped.
+	if err := os.Mkdir(filepath.Join(dir, "certs", "ca-3.d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	config := struct {
+		Bundle  string `file:"ca-*"`
+		Nested  string `file:"certs/ca-*"`
+		Missing string `file:"missing-*"`
+	}{}
+
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	if err := ParseWithDir(&config, dir); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.Bundle != "first\nsecond\n" {
+		t.Errorf("Expected the matching files to be concatenated in order but got %q", config.Bundle)
+	}
+	if config.Nested != "second\n" {
+		t.Errorf("Expected only the nested file to match but got %q", config.Nested)
+	}
+	if config.Missing != "" {
+		t.Errorf("Expected no value when no files match but got %q", config.Missing)
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestFileExists(t *testing.T) {
+	filevalues := make(map[string]configFile)
+	filevalues["maintenance"] = configFile{
+		subDirs:  "",
+		contents: "",
+	}
+	filevalues["verbose"] = configFile{
+		subDirs:  "",
+		contents: "false",
+	}
+
+	dir, err := createFilesInTempDir(filevalues)
+	if err != nil {
+		t.Errorf("Could not create files in temp dir: %v", err)
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	config := struct {
+		Maintenance bool `fileexists:"true"`
+		Verbose     bool `fileexists:"true"`
+		Readonly    bool `fileexists:"true"`
+	}{}
+
+	// A missing file means false, even if the environment variable is set.
+	t.Setenv("READONLY", "true")
+	setFlags([]string{})
+	if err := ParseWithDir(&config, dir); err != nil {
+		t.Errorf("Unexpected error while parsing config directory: %v", err)
+		return
+	}
+
+	if !config.Maintenance {
+		t.Errorf("maintenance was an unexpected value: %v", config.Maintenance)
+	}
+
+	// The file's contents should be ignored.
+	if !config.Verbose {
+		t.Errorf("verbose was an unexpected value: %v", config.Verbose)
+	}
+
+	if config.Readonly {
+		t.Errorf("readonly was an unexpected value: %v", config.Readonly)
+	}
+
+	// A default would make a missing file mean something other than false.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	withDefault := struct {
+		Missing bool `fileexists:"true" default:"true"`
+	}{}
+	if err := ParseWithDir(&withDefault, dir); err == nil {
+		t.Errorf("Expected an error for a fileexists field with a default but got %v", withDefault.Missing)
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestStripInlineComments(t *testing.T) {
+	filevalues := make(map[string]configFile)
+	filevalues["port"] = configFile{
+		subDirs:  "",
+		contents: "8080 # port",
+	}
+	filevalues["password"] = configFile{
+		subDirs:  "",
+		contents: "abc#def",
+	}
+	filevalues["hostname"] = configFile{
+		subDirs:  "",
+		contents: "localhost",
+	}
+
+	dir, err := createFilesInTempDir(filevalues)
+	if err != nil {
+		t.Errorf("Could not create files in temp dir: %v", err)
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	type Config struct {
+		Port     int
+		Password string
+		Hostname string
+	}
+
+	tables := []struct {
+		opts     Options
+		expected Config
+		isErr    bool
+	}{
+		{Options{}, Config{}, true}, // comment is treated as part of the int value
+		{Options{StripInlineComments: true}, Config{8080, "abc", "localhost"}, false},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags([]string{})
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		result := Config{}
+		err := ParseWithOptions(&result, dir, table.opts)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error while parsing config directory: %v", err)
+			continue
+		}
+		if result != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestEnvSuffix(t *testing.T) {
+	filevalues := make(map[string]configFile)
+	filevalues["suffixport"] = configFile{
+		subDirs:  "",
+		contents: "8080",
+	}
+	filevalues["suffixport.production"] = configFile{
+		subDirs:  "",
+		contents: "80",
+	}
+	filevalues["suffixhost"] = configFile{
+		subDirs:  "",
+		contents: "shared.example.com",
+	}
+	filevalues["suffixhost.staging"] = configFile{
+		subDirs:  "",
+		contents: "staging.example.com",
+	}
+
+	dir, err := createFilesInTempDir(filevalues)
+	if err != nil {
+		t.Errorf("Could not create files in temp dir: %v", err)
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	type Config struct {
+		SuffixPort int    `noenv:"true"`
+		SuffixHost string `noenv:"true"`
+	}
+
+	tables := []struct {
+		suffix   string
+		expected Config
+	}{
+		{"", Config{8080, "shared.example.com"}},
+		{"production", Config{80, "shared.example.com"}},
+		{"staging", Config{8080, "staging.example.com"}},
+		{"development", Config{8080, "shared.example.com"}},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags([]string{})
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		result := Config{}
+		if err := ParseWithOptions(&result, dir, Options{EnvSuffix: table.suffix}); err != nil {
+			t.Errorf("Unexpected error while parsing config directory: %v", err)
+			continue
+		}
+		if result != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestBlankFileAsUnset(t *testing.T) {
+	filevalues := make(map[string]configFile)
+	filevalues["blankport"] = configFile{
+		subDirs:  "",
+		contents: " \n\t\n",
+	}
+	filevalues["blankname"] = configFile{
+		subDirs:  "",
+		contents: "",
+	}
+
+	dir, err := createFilesInTempDir(filevalues)
+	if err != nil {
+		t.Errorf("Could not create files in temp dir: %v", err)
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	type Config struct {
+		BlankPort int    `default:"8080"`
+		BlankName string `env:"BLANK_FILE_NAME" default:"web"`
+	}
+
+	tables := []struct {
+		opts     Options
+		env      string
+		expected Config
+		isErr    bool
+	}{
+		{Options{}, "", Config{}, true}, // whitespace is not a valid int
+		{Options{BlankFileAsUnset: true}, "", Config{8080, "web"}, false},
+		{Options{BlankFileAsUnset: true}, "api", Config{8080, "api"}, false},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags([]string{})
+		if table.env == "" {
+			os.Unsetenv("BLANK_FILE_NAME")
+		} else {
+			os.Setenv("BLANK_FILE_NAME", table.env)
+		}
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		result := Config{}
+		err := ParseWithOptions(&result, dir, table.opts)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error while parsing config directory: %v", err)
+			continue
+		}
+		if result != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+	os.Unsetenv("BLANK_FILE_NAME")
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+type endpoint struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+func (e *endpoint) UnmarshalJSON(b []byte) error {
+	type plain endpoint
+	var p plain
+	if err := json.Unmarshal(b, &p); err != nil {
+		return err
+	}
+	if p.Host == "" {
+		return errors.New("host is missing")
+	}
+	*e = endpoint(p)
+	return nil
+}
+
+type upperString string
+
+func (u *upperString) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	*u = upperString(strings.ToUpper(s))
+	return nil
+}
+
+func TestJSONUnmarshaler(t *testing.T) {
+	type Config struct {
+		Backend endpoint    `format:"json"`
+		Region  upperString `default:"us-east"`
+	}
+
+	tables := []struct {
+		backend  string
+		region   string
+		expected Config
+		isErr    bool
+	}{
+		{`{"host":"db","port":5432}`, "", Config{endpoint{"db", 5432}, "US-EAST"}, false},          // json object in env, non-json default
+		{`{"host":"db","port":5432}`, `"eu-west"`, Config{endpoint{"db", 5432}, "EU-WEST"}, false}, // json string in env
+		{`{"host":"db","port":5432}`, "eu-west", Config{endpoint{"db", 5432}, "EU-WEST"}, false},   // plain string in env
+		{`{"port":5432}`, "", Config{}, true},                                                      // UnmarshalJSON returns an error
+		{`db:5432`, "", Config{}, true},                                                            // not json, but field is tagged with the json format
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags([]string{})
+		os.Setenv("BACKEND", table.backend)
+		if table.region == "" {
+			os.Unsetenv("REGION")
+		} else {
+			os.Setenv("REGION", table.region)
+		}
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		result := Config{}
+		err := Parse(&result)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+
+	os.Unsetenv("BACKEND")
+	os.Unsetenv("REGION")
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestJSONStruct(t *testing.T) {
+	type DBConfig struct {
+		Host string `json:"host"`
+		Port int    `json:"port"`
+		User string `json:"user"`
+	}
+	type Config struct {
+		DB DBConfig `format:"json"`
+	}
+
+	tables := []struct {
+		env      map[string]string
+		expected DBConfig
+		isErr    bool
+	}{
+		{map[string]string{"DB": `{"host":"x","port":5}`}, DBConfig{"x", 5, ""}, false},
+		{map[string]string{"DB": `{"host":"x","port":5}`, "DB_PORT": "6", "DB_USER": "admin"}, DBConfig{"x", 6, "admin"}, false},
+		{map[string]string{"DB_HOST": "y"}, DBConfig{"y", 0, ""}, false},
+		{map[string]string{"DB": `{"host":`}, DBConfig{}, true},
+		{map[string]string{"DB": `{"host":"x"}`, "DB_PORT": "abc"}, DBConfig{}, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags([]string{})
+		for k, v := range table.env {
+			os.Setenv(k, v)
+		}
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		result := Config{}
+		err := Parse(&result)
+		for k := range table.env {
+			os.Unsetenv(k)
+		}
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result.DB != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result.DB)
+		}
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestMetrics(t *testing.T) {
+	filevalues := make(map[string]configFile)
+	filevalues["username"] = configFile{
+		subDirs:  "",
+		contents: "admin",
+	}
+	filevalues["password"] = configFile{
+		subDirs:  "sub",
+		contents: "mypassword",
+	}
+
+	dir, err := createFilesInTempDir(filevalues)
+	if err != nil {
+		t.Errorf("Could not create files in temp dir: %v", err)
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	config := struct {
+		Username string
+		Password string
+		Port     int `default:"8080"`
+		Async    bool
+	}{}
+
+	setFlags([]string{})
+	os.Unsetenv("ASYNC")
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	metrics := ParseMetrics{FilesScanned: 100}
+	if err := ParseWithOptions(&config, dir, Options{Metrics: &metrics}); err != nil {
+		t.Errorf("Unexpected error while parsing config directory: %v", err)
+		return
+	}
+
+	if metrics.FilesScanned != 2 {
+		t.Errorf("Expected 2 files scanned but got %d instead", metrics.FilesScanned)
+	}
+	if metrics.FieldsResolved != 3 {
+		t.Errorf("Expected 3 fields resolved but got %d instead", metrics.FieldsResolved)
+	}
+	if metrics.DirScan < 0 || metrics.FlagParse < 0 || metrics.SourceLookup < 0 {
+		t.Errorf("Unexpected timings: %+v", metrics)
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestDeprecated(t *testing.T) {
+	type Config struct {
+		Hostname string `env:"HOST" flag:"host"`
+		OldHost  string `default:"localhost" deprecated:"use HOST instead"`
+	}
+
+	tables := []struct {
+		flags   []string
+		oldhost string
+		warning bool
+	}{
+		{[]string{}, "", false},                         // only the default is used, so no warning
+		{[]string{}, "example.com", true},               // set from env
+		{[]string{"-oldhost", "example.com"}, "", true}, // set from flag
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+		os.Unsetenv("HOST")
+		if table.oldhost == "" {
+			os.Unsetenv("OLDHOST")
+		} else {
+			os.Setenv("OLDHOST", table.oldhost)
+		}
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		logs := new(bytes.Buffer)
+		result := Config{}
+		if err := ParseWithOptions(&result, "", Options{Logger: log.New(logs, "", 0)}); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+
+		warned := strings.Contains(logs.String(), "use HOST instead")
+		if warned != table.warning {
+			t.Errorf("Expected warning to be %v but got %v instead - log output: %v", table.warning, warned, logs.String())
+		}
+		if result.OldHost == "" {
+			t.Error("Deprecated field was not set")
+		}
+	}
+
+	os.Unsetenv("OLDHOST")
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestSlices(t *testing.T) {
+	type Config struct {
+		Servers []string `envindexed:"SERVER"`
+		Ports   []int    `separator:";"`
+		Flags   []bool
+	}
+
+	os.Setenv("SERVER_0", "a.example.com")
+	os.Setenv("SERVER_1", "b.example.com")
+	os.Setenv("SERVER_2", "c.example.com")
+	os.Setenv("SERVER_4", "ignored.example.com")
+	os.Setenv("SERVERS", "ignored.example.com")
+	os.Setenv("PORTS", "80; 443")
+	setFlags([]string{"-flags", "true,false"})
+	defer func() {
+		for _, k := range []string{"SERVER_0", "SERVER_1", "SERVER_2", "SERVER_4", "SERVERS", "PORTS"} {
+			os.Unsetenv(k)
+		}
+	}()
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	result := Config{}
+	if err := Parse(&result); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result.Servers, []string{"a.example.com", "b.example.com", "c.example.com"}) {
+		t.Errorf("Unexpected servers: %v", result.Servers)
+	}
+	if !reflect.DeepEqual(result.Ports, []int{80, 443}) {
+		t.Errorf("Unexpected ports: %v", result.Ports)
+	}
+	if !reflect.DeepEqual(result.Flags, []bool{true, false}) {
+		t.Errorf("Unexpected flags: %v", result.Flags)
+	}
+
+	// Without any indexed variables, the plain environment variable is used.
+	os.Unsetenv("SERVER_0")
+	os.Setenv("PORTS", "80;abc")
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	result = Config{}
+	if err := Parse(&result); err == nil {
+		t.Error("Expected an error for an invalid slice element but did not get it")
+	} else {
+		t.Logf("Expected an error - got: %v", err)
+	}
+	if !reflect.DeepEqual(result.Servers, []string{"ignored.example.com"}) {
+		t.Errorf("Unexpected servers: %v", result.Servers)
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestSliceOrder(t *testing.T) {
+	type Config struct {
+		Backends []string `noflag:"true"`
+		Weights  []int    `noenv:"true"`
+	}
+
+	tables := []struct {
+		env      map[string]string
+		args     []string
+		expected Config
+	}{
+		{map[string]string{"BACKENDS": "c.example.com,a.example.com,b.example.com,a.example.com"}, []string{"-weights", "3,1,2,1"}, Config{[]string{"c.example.com", "a.example.com", "b.example.com", "a.example.com"}, []int{3, 1, 2, 1}}},
+		{map[string]string{"BACKENDS": "z, y ,x"}, []string{}, Config{[]string{"z", "y", "x"}, nil}},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		result := Config{}
+		if err := ParseDeterministic(&result, "", table.env, table.args); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if !reflect.DeepEqual(result, table.expected) {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+}
+
+func TestStructSlices(t *testing.T) {
+	type Upstream struct {
+		Host    string
+		Port    int `default:"80"`
+		Weights []int
+	}
+	type Config struct {
+		Upstreams []Upstream `envindexed:"UPSTREAM"`
+		Ignored   []Upstream
+	}
+
+	env := map[string]string{
+		"UPSTREAM_0_HOST":    "a.example.com",
+		"UPSTREAM_0_PORT":    "8080",
+		"UPSTREAM_0_WEIGHTS": "1,2",
+		"UPSTREAM_1_HOST":    "b.example.com",
+		"UPSTREAM_3_HOST":    "ignored.example.com",
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+	}
+	defer func() {
+		for k := range env {
+			os.Unsetenv(k)
+		}
+	}()
+
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	result := Config{}
+	if err := Parse(&result); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	expected := []Upstream{
+		{"a.example.com", 8080, []int{1, 2}},
+		{"b.example.com", 80, nil},
+	}
+	if !reflect.DeepEqual(result.Upstreams, expected) {
+		t.Errorf("Expected upstreams %+v but got %+v instead", expected, result.Upstreams)
+	}
+	if result.Ignored != nil {
+		t.Errorf("Expected a slice of structs without an envindexed tag to be skipped but got %+v", result.Ignored)
+	}
+
+	os.Setenv("UPSTREAM_1_PORT", "http")
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	if err := Parse(&Config{}); err == nil {
+		t.Error("Expected an error for an invalid element field but did not get it")
+	} else {
+		t.Logf("Expected an error - got: %v", err)
+	}
+	os.Unsetenv("UPSTREAM_1_PORT")
+
+	// An invalid default in the element struct is reported too.
+	type BadUpstream struct {
+		Host string
+		Port int `default:"http"`
+	}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	if err := Parse(&struct {
+		Upstreams []BadUpstream `envindexed:"UPSTREAM"`
+	}{}); err == nil {
+		t.Error("Expected an error for an invalid element default but did not get it")
+	} else if !strings.HasPrefix(err.Error(), "element 0 of") {
+		t.Errorf("Expected the error to name the element but got: %v", err)
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestScanDir(t *testing.T) {
+	filevalues := make(map[string]configFile)
+	filevalues["username"] = configFile{
+		subDirs:  "",
+		contents: "admin",
+	}
+	filevalues["maxretries"] = configFile{
+		subDirs:  "1",
+		contents: "5",
+	}
+
+	dir, err := createFilesInTempDir(filevalues)
+	if err != nil {
+		t.Errorf("Could not create files in temp dir: %v", err)
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	fileMap, err := ScanDir(dir)
+	if err != nil {
+		t.Errorf("Unexpected error while scanning config directory: %v", err)
+		return
+	}
+	if len(fileMap) != 2 {
+		t.Errorf("Expected 2 files but got %d instead: %v", len(fileMap), fileMap)
+	}
+
+	// The same file map should be usable for multiple structs.
+	auth := struct {
+		Username string
+	}{}
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	if err := ParseWithFileMap(&auth, fileMap); err != nil {
+		t.Errorf("Unexpected error while parsing file map: %v", err)
+	}
+	if auth.Username != "admin" {
+		t.Errorf("username was an unexpected value: %v", auth.Username)
+	}
+
+	retry := struct {
+		MaxRetries int
+	}{}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	if err := ParseWithFileMap(&retry, fileMap); err != nil {
+		t.Errorf("Unexpected error while parsing file map: %v", err)
+	}
+	if retry.MaxRetries != 5 {
+		t.Errorf("maxretries was an unexpected value: %v", retry.MaxRetries)
+	}
+
+	if _, err := ScanDir(filepath.Join(dir, "doesnotexist")); err == nil {
+		t.Error("Expected an error scanning a missing directory but did not get it")
+	} else {
+		t.Logf("Expected an error - got: %v", err)
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+type point struct {
+	X, Y int
+}
+
+func TestConverters(t *testing.T) {
+	type Config struct {
+		Address net.IP
+		Origin  point
+		Route   []point
+		Ignored complex128
+	}
+
+	opts := Options{}
+	opts.RegisterConverter(reflect.TypeOf(net.IP{}), func(s string) (interface{}, error) {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("%q is not an IP address", s)
+		}
+		return ip, nil
+	})
+	opts.RegisterConverter(reflect.TypeOf(point{}), func(s string) (interface{}, error) {
+		var p point
+		if _, err := fmt.Sscanf(s, "%d:%d", &p.X, &p.Y); err != nil {
+			return nil, err
+		}
+		return p, nil
+	})
+
+	tables := []struct {
+		flags    []string
+		expected Config
+		isErr    bool
+	}{
+		{[]string{"-address", "10.0.0.1", "-origin", "1:2", "-route", "1:2,3:4"}, Config{net.ParseIP("10.0.0.1"), point{1, 2}, []point{{1, 2}, {3, 4}}, 0}, false},
+		{[]string{"-address", "10.0.0.300"}, Config{}, true}, // converter returns an error
+	}
+
+	os.Unsetenv("ADDRESS")
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		stderr := new(bytes.Buffer)
+		flag.CommandLine.SetOutput(stderr)
+
+		result := Config{}
+		err := ParseWithOptions(&result, "", opts)
+		if table.isErr {
+			if err == nil && stderr.Len() == 0 {
+				t.Error("Expected an error but did not get it")
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if !reflect.DeepEqual(result, table.expected) {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+
+	// Without the converters, the fields are skipped.
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	logs := new(bytes.Buffer)
+	result := Config{}
+	if err := ParseWithOptions(&result, "", Options{Logger: log.New(logs, "", 0)}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(logs.String(), "skipping field Origin") {
+		t.Errorf("Expected Origin to be skipped - log output: %v", logs.String())
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestOnConflict(t *testing.T) {
+	filevalues := make(map[string]configFile)
+	filevalues["username"] = configFile{
+		subDirs:  "",
+		contents: "admin",
+	}
+	filevalues["password"] = configFile{
+		subDirs:  "",
+		contents: "mypassword",
+	}
+	filevalues["maxretries"] = configFile{
+		subDirs:  "",
+		contents: "5",
+	}
+
+	dir, err := createFilesInTempDir(filevalues)
+	if err != nil {
+		t.Errorf("Could not create files in temp dir: %v", err)
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	config := struct {
+		Username   string
+		Password   string
+		MaxRetries int
+	}{}
+
+	os.Setenv("USERNAME", "root")
+	os.Setenv("PASSWORD", "mypassword")
+	os.Unsetenv("MAXRETRIES")
+	defer os.Unsetenv("USERNAME")
+	defer os.Unsetenv("PASSWORD")
+
+	var conflicts []string
+	opts := Options{
+		OnConflict: func(field, fileVal, envVal string) {
+			conflicts = append(conflicts, fmt.Sprintf("%s:%s:%s", field, fileVal, envVal))
+		},
+	}
+
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	if err := ParseWithOptions(&config, dir, opts); err != nil {
+		t.Errorf("Unexpected error while parsing config directory: %v", err)
+		return
+	}
+
+	// Only the username disagrees - the password matches and maxretries has
+	// no environment variable.
+	if !reflect.DeepEqual(conflicts, []string{"Username:admin:root"}) {
+		t.Errorf("Unexpected conflicts: %v", conflicts)
+	}
+	if config.Username != "admin" {
+		t.Errorf("username was an unexpected value: %v", config.Username)
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestRelFile(t *testing.T) {
+	filevalues := make(map[string]configFile)
+	filevalues["VERSION"] = configFile{
+		subDirs:  "",
+		contents: "1.2.3",
+	}
+	filevalues["BUILD"] = configFile{
+		subDirs:  "meta",
+		contents: "42",
+	}
+
+	dir, err := createFilesInTempDir(filevalues)
+	if err != nil {
+		t.Errorf("Could not create files in temp dir: %v", err)
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Errorf("Could not get working directory: %v", err)
+		return
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Errorf("Could not change into temp dir: %v", err)
+		return
+	}
+	defer os.Chdir(wd)
+
+	config := struct {
+		Version string `relfile:"VERSION"`
+		Build   int    `relfile:"meta/BUILD"`
+		Commit  string `relfile:"COMMIT" default:"unknown"`
+	}{}
+
+	setFlags([]string{"-commit", "abc123"})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	if err := Parse(&config); err != nil {
+		t.Errorf("Unexpected error while parsing: %v", err)
+		return
+	}
+
+	if config.Version != "1.2.3" {
+		t.Errorf("version was an unexpected value: %v", config.Version)
+	}
+	if config.Build != 42 {
+		t.Errorf("build was an unexpected value: %v", config.Build)
+	}
+
+	// COMMIT does not exist, so the flag should be used.
+	if config.Commit != "abc123" {
+		t.Errorf("commit was an unexpected value: %v", config.Commit)
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestUnknownFlags(t *testing.T) {
+	type Config struct {
+		Port  int
+		Debug bool
+	}
+
+	setFlags([]string{"-port", "8080", "-verbose", "-debug"})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	stderr := new(bytes.Buffer)
+	flag.CommandLine.SetOutput(stderr)
+	err := Parse(&Config{})
+	if err == nil {
+		t.Fatal("Expected an error for an unknown flag but did not get it")
+	}
+	if !strings.Contains(err.Error(), "-verbose") {
+		t.Errorf("Expected the error to name the unknown flag but got: %v", err)
+	}
+	if stderr.Len() > 0 {
+		t.Errorf("Expected no output from the flag package but got: %q", stderr.String())
+	}
+
+	// With UnknownFlagsAsWarnings, the unknown flag is logged and the
+	// following flags are still parsed.
+	var logbuf bytes.Buffer
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	stderr.Reset()
+	flag.CommandLine.SetOutput(stderr)
+	result := Config{}
+	opts := Options{Logger: log.New(&logbuf, "", 0), UnknownFlagsAsWarnings: true}
+	if err := ParseWithOptions(&result, "", opts); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Port != 8080 || !result.Debug {
+		t.Errorf("Expected port 8080 and debug true but got %+v", result)
+	}
+	if !strings.Contains(logbuf.String(), "-verbose") {
+		t.Errorf("Expected a warning naming the unknown flag but got: %q", logbuf.String())
+	}
+	if stderr.Len() > 0 {
+		t.Errorf("Expected no output from the flag package but got: %q", stderr.String())
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestSpaceSeparatedBoolFlags(t *testing.T) {
+	type Config struct {
+		Async bool   `noenv:"true"`
+		Debug bool   `noenv:"true"`
+		Name  string `noenv:"true"`
+	}
+
+	tables := []struct {
+		flags    []string
+		opts     Options
+		expected Config
+		args     []string
+	}{
+		{[]string{"-async"}, Options{}, Config{true, false, ""}, []string{}},
+		{[]string{"-async", "false"}, Options{}, Config{true, false, ""}, []string{"false"}},
+		{[]string{"-async"}, Options{SpaceSeparatedBoolFlags: true}, Config{true, false, ""}, []string{}},
+		{[]string{"-async", "true", "-debug"}, Options{SpaceSeparatedBoolFlags: true}, Config{true, true, ""}, []string{}},
+		{[]string{"-async", "FALSE", "-debug", "file"}, Options{SpaceSeparatedBoolFlags: true}, Config{false, true, ""}, []string{"file"}},
+		{[]string{"-name", "true", "-async", "false"}, Options{SpaceSeparatedBoolFlags: true}, Config{false, false, "true"}, []string{}},
+		{[]string{"-debug", "--", "-async", "true"}, Options{SpaceSeparatedBoolFlags: true}, Config{false, true, ""}, []string{"-async", "true"}},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		result := Config{}
+		if err := ParseWithOptions(&result, "", table.opts); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+		if args := flag.Args(); !reflect.DeepEqual(args, table.args) {
+			t.Errorf("Expected remaining arguments %v but got %v instead", table.args, args)
+		}
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestGenerateNegatedBoolFlags(t *testing.T) {
+	type Config struct {
+		Async bool   `noenv:"true" default:"true"`
+		Debug bool   `noenv:"true"`
+		Name  string `noenv:"true"`
+	}
+
+	tables := []struct {
+		flags    []string
+		expected Config
+		isErr    bool
+	}{
+		{[]string{}, Config{true, false, ""}, false},
+		{[]string{"--no-async"}, Config{false, false, ""}, false},
+		{[]string{"-no-async", "-debug"}, Config{false, true, ""}, false},
+		{[]string{"-no-async=false"}, Config{true, false, ""}, false},
+		{[]string{"-no-debug", "-no-debug"}, Config{true, false, ""}, false},
+		{[]string{"-async", "-no-async"}, Config{}, true},
+		{[]string{"-no-async", "-async=false"}, Config{}, true},
+		{[]string{"-no-name"}, Config{}, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		stderr := new(bytes.Buffer)
+		flag.CommandLine.SetOutput(stderr)
+
+		result := Config{}
+		err := ParseWithOptions(&result, "", Options{GenerateNegatedBoolFlags: true})
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestNoFields(t *testing.T) {
+	config := struct {
+		Ratio   complex128
+		private string
+	}{}
+
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	logger := log.New(new(bytes.Buffer), "", 0)
+	if err := ParseWithOptions(&config, "", Options{Logger: logger}); err != nil {
+		t.Errorf("Unexpected error with default options: %v", err)
+	}
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	err := ParseWithOptions(&config, "", Options{Logger: logger, ErrorOnNoFields: true})
+	if err != ErrNoFields {
+		t.Errorf("Expected ErrNoFields but got %v instead", err)
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestRetrieveConfigDirectory(t *testing.T) {
+	tables := []struct {
+		envKey   string
+		flagKey  string
+		flags    []string
+		env      string
+		expected string
+	}{
+		{"CONFIGDIR", "configdir", []string{"-configdir", "/flag"}, "/env", "/env"}, // env takes precedence
+		{"CONFIGDIR", "configdir", []string{"-configdir", "/flag"}, "", "/flag"},    // env unset, so flag is used
+		{"CONFIGDIR", "configdir", []string{}, "", "/default"},                      // neither set
+		{"", "configdir", []string{"-configdir", "/flag"}, "/env", "/flag"},         // no env key
+		{"CONFIGDIR", "", []string{"-configdir", "/flag"}, "", "/default"},          // no flag key
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+		if table.env == "" {
+			os.Unsetenv("CONFIGDIR")
+		} else {
+			os.Setenv("CONFIGDIR", table.env)
+		}
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		dir := RetrieveConfigDirectory(table.envKey, table.flagKey, "/default")
+		if dir != table.expected {
+			t.Errorf("Expected %v but got %v instead", table.expected, dir)
+		}
+	}
+	os.Unsetenv("CONFIGDIR")
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestTimeFields(t *testing.T) {
+	type Config struct {
+		Start   time.Time
+		Date    time.Time `layout:"2006-01-02"`
+		Created time.Time `unixtime:"s"`
+		Updated time.Time `unixtime:"ms"`
+	}
+
+	tables := []struct {
+		flags    []string
+		expected Config
+		isErr    bool
+	}{
+		{
+			[]string{"-start", "2021-03-04T05:06:07Z", "-date", "2021-03-04", "-created", "1614834367", "-updated", "1614834367500"},
+			Config{
+				time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC),
+				time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC),
+				time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC),
+				time.Date(2021, 3, 4, 5, 6, 7, 500000000, time.UTC),
+			},
+			false,
+		},
+		{[]string{"-created", "2021-03-04T05:06:07Z"}, Config{}, true}, // not a timestamp
+		{[]string{"-date", "04/03/2021"}, Config{}, true},              // wrong layout
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		stderr := new(bytes.Buffer)
+		flag.CommandLine.SetOutput(stderr)
+
+		result := Config{}
+		err := Parse(&result)
+		if table.isErr {
+			if stderr.Len() == 0 {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", stderr.String())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if !result.Start.Equal(table.expected.Start) || !result.Date.Equal(table.expected.Date) || !result.Created.Equal(table.expected.Created) || !result.Updated.Equal(table.expected.Updated) {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+
+	// layout and unixtime are mutually exclusive.
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	invalid := struct {
+		Start time.Time `layout:"2006-01-02" unixtime:"s"`
+	}{}
+	if err := Parse(&invalid); err == nil {
+		t.Error("Expected an error for a field with both layout and unixtime tags but did not get it")
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestTimeLayouts(t *testing.T) {
+	type Config struct {
+		Start    time.Time   `layoutname:"iso"`
+		End      time.Time   `layoutname:"iso"`
+		Holidays []time.Time `layoutname:"iso"`
+	}
+
+	layouts := map[string]string{"iso": "2006-01-02"}
+	day := func(d int) time.Time {
+		return time.Date(2021, time.March, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	tables := []struct {
+		opts     Options
+		env      map[string]string
+		expected Config
+		isErr    bool
+	}{
+		{Options{TimeLayouts: layouts}, map[string]string{"START": "2021-03-01", "END": "2021-03-31", "HOLIDAYS": "2021-03-04,2021-03-05"}, Config{day(1), day(31), []time.Time{day(4), day(5)}}, false},
+		{Options{TimeLayouts: layouts}, map[string]string{"START": "2021-03-01T00:00:00Z"}, Config{}, true},
+		{Options{TimeLayouts: map[string]string{"us": "01/02/2006"}}, map[string]string{}, Config{}, true},
+		{Options{}, map[string]string{}, Config{}, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		pr := deterministicParser("", table.env, nil, table.opts)
+
+		result := Config{}
+		err := pr.Parse(&result)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if !reflect.DeepEqual(result, table.expected) {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+
+	invalid := struct {
+		Start time.Time `layout:"2006" layoutname:"iso"`
+	}{}
+	pr := deterministicParser("", nil, nil, Options{TimeLayouts: layouts})
+	if err := pr.Parse(&invalid); err == nil {
+		t.Error("Expected an error for both a layout and a layoutname tag but did not get it")
+	}
+}
+
+func TestTimeSlices(t *testing.T) {
+	type Config struct {
+		RunAt []time.Time `noenv:"true"`
+		Dates []time.Time `noenv:"true" layout:"2006-01-02" separator:";"`
+	}
+
+	setFlags([]string{"-runat", "2021-03-04T05:06:07Z, 2021-03-05T06:07:08Z", "-dates", "2021-03-04;2021-03-05"})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	result := Config{}
+	if err := Parse(&result); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	expected := Config{
+		[]time.Time{time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC), time.Date(2021, 3, 5, 6, 7, 8, 0, time.UTC)},
+		[]time.Time{time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC), time.Date(2021, 3, 5, 0, 0, 0, 0, time.UTC)},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v but got %v instead", expected, result)
+	}
+
+	// An invalid element is reported along with its index.
+	setFlags([]string{"-runat", "2021-03-04T05:06:07Z,tomorrow"})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	stderr := new(bytes.Buffer)
+	flag.CommandLine.SetOutput(stderr)
+	result = Config{}
+	err := Parse(&result)
+	if err == nil || !strings.Contains(err.Error(), "element 1") || !strings.Contains(err.Error(), "tomorrow") {
+		t.Errorf("Expected an error naming element 1 and its value but got %v instead", err)
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestRegexps(t *testing.T) {
+	type Config struct {
+		Include  *regexp.Regexp   `noenv:"true"`
+		Exclude  *regexp.Regexp   `noenv:"true" default:"\\.tmp$"`
+		Patterns []*regexp.Regexp `noenv:"true" separator:" "`
+	}
+
+	tables := []struct {
+		flags    []string
+		expected []string
+		isErr    bool
+	}{
+		{[]string{}, []string{"", `\.tmp$`, ""}, false},
+		{[]string{"-include", "^/api/", "-patterns", "a+ b*"}, []string{"^/api/", `\.tmp$`, "a+ b*"}, false},
+		{[]string{"-include", "("}, nil, true},
+		{[]string{"-patterns", "a+ [b"}, nil, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		stderr := new(bytes.Buffer)
+		flag.CommandLine.SetOutput(stderr)
+
+		result := Config{}
+		err := Parse(&result)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else if !strings.Contains(err.Error(), "regular expression") {
+				t.Errorf("Expected an error about the regular expression but got: %v", err)
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		var patterns []string
+		for _, re := range result.Patterns {
+			patterns = append(patterns, re.String())
+		}
+		actual := []string{"", result.Exclude.String(), strings.Join(patterns, " ")}
+		if result.Include != nil {
+			actual[0] = result.Include.String()
+		}
+		if !reflect.DeepEqual(actual, table.expected) {
+			t.Errorf("Expected %q but got %q instead", table.expected, actual)
+		}
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestFileMode(t *testing.T) {
+	type Config struct {
+		DirPerm  os.FileMode `default:"0700"`
+		FilePerm os.FileMode `base:"0"`
+		Mask     os.FileMode `base:"10"`
+	}
+
+	tables := []struct {
+		flags    []string
+		expected Config
+		isErr    bool
+	}{
+		{[]string{}, Config{0700, 0, 0}, false},
+		{[]string{"-dirperm", "0755", "-fileperm", "0o644", "-mask", "18"}, Config{0755, 0644, 022}, false},
+		{[]string{"-dirperm", "755", "-fileperm", "420"}, Config{0755, 0644, 0}, false},
+		{[]string{"-dirperm", "0789"}, Config{}, true},
+		{[]string{"-mask", "rwx"}, Config{}, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		stderr := new(bytes.Buffer)
+		flag.CommandLine.SetOutput(stderr)
+
+		result := Config{}
+		err := Parse(&result)
+		if table.isErr {
+			if stderr.Len() == 0 {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", stderr.String())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+
+	// The base tag only applies to os.FileMode fields.
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	invalid := struct {
+		Port int `base:"16"`
+	}{}
+	if err := Parse(&invalid); err == nil {
+		t.Error("Expected an error for a base tag on an int but did not get it")
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestFloats(t *testing.T) {
+	type Config struct {
+		Ratio   float64
+		Pi      float64   `decimalcomma:"true"`
+		Weights []float32 `decimalcomma:"true" separator:";"`
+	}
+
+	tables := []struct {
+		flags    []string
+		expected Config
+		isErr    bool
+	}{
+		{[]string{"-ratio", "0.5", "-pi", "3,14", "-weights", "1,5; 2"}, Config{0.5, 3.14, []float32{1.5, 2}}, false},
+		{[]string{"-pi", "3.14"}, Config{0, 3.14, nil}, false},
+		{[]string{"-ratio", "3,14"}, Config{}, true}, // no decimalcomma tag
+		{[]string{"-pi", "1,000,5"}, Config{}, true}, // more than one comma
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		stderr := new(bytes.Buffer)
+		flag.CommandLine.SetOutput(stderr)
+
+		result := Config{}
+		err := Parse(&result)
+		if table.isErr {
+			if stderr.Len() == 0 {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", stderr.String())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if !reflect.DeepEqual(result, table.expected) {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+
+	invalid := []interface{}{
+		&struct {
+			Count int `decimalcomma:"true"`
+		}{},
+		&struct {
+			Price float64 `decimalcomma:"true" strip:","`
+		}{},
+		&struct {
+			Weights []float64 `decimalcomma:"true"`
+		}{},
+	}
+	for _, config := range invalid {
+		setFlags([]string{})
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		if err := Parse(config); err == nil {
+			t.Errorf("Expected an error for %T but did not get it", config)
+		}
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestStrip(t *testing.T) {
+	type Config struct {
+		Number int   `strip:"- "`
+		Codes  []int `strip:"-"`
+	}
+
+	setFlags([]string{"-number", "1-800 555", "-codes", "1-2,3-4"})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	result := Config{}
+	if err := Parse(&result); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if result.Number != 1800555 {
+		t.Errorf("Expected number 1800555 but got %v instead", result.Number)
+	}
+	if !reflect.DeepEqual(result.Codes, []int{12, 34}) {
+		t.Errorf("Expected codes [12 34] but got %v instead", result.Codes)
+	}
+
+	// The strip tag only applies to numeric fields.
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	invalid := struct {
+		Phone string `strip:"-"`
+	}{}
+	if err := Parse(&invalid); err == nil {
+		t.Error("Expected an error for a strip tag on a string but did not get it")
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestMaps(t *testing.T) {
+	type Config struct {
+		Limits   map[string]int           `noenv:"true"`
+		Timeouts map[string]time.Duration `noenv:"true" separator:";"`
+		Enabled  map[int]bool             `noenv:"true"`
+	}
+
+	tables := []struct {
+		flags    []string
+		expected Config
+		errMsg   string
+	}{
+		{[]string{}, Config{}, ""},
+		{
+			[]string{"-limits", "a=1, b = 2", "-timeouts", "read=1s;write=2m", "-enabled", "1=true,2=false"},
+			Config{map[string]int{"a": 1, "b": 2}, map[string]time.Duration{"read": time.Second, "write": 2 * time.Minute}, map[int]bool{1: true, 2: false}},
+			"",
+		},
+		{[]string{"-limits", "a=1,a=3"}, Config{Limits: map[string]int{"a": 3}}, ""},
+		{[]string{"-limits", "a=1,bad=x"}, Config{}, `"bad=x"`},
+		{[]string{"-limits", "a=1,b"}, Config{}, `"b"`},
+		{[]string{"-enabled", "one=true"}, Config{}, `"one=true"`},
+		{[]string{"-timeouts", "read=1s;slow=forever"}, Config{}, `"slow=forever"`},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		stderr := new(bytes.Buffer)
+		flag.CommandLine.SetOutput(stderr)
+
+		result := Config{}
+		err := Parse(&result)
+		if table.errMsg != "" {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else if !strings.Contains(err.Error(), table.errMsg) {
+				t.Errorf("Expected the error to mention %s but got: %v", table.errMsg, err)
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if !reflect.DeepEqual(result, table.expected) {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestSourceSeparators(t *testing.T) {
+	filevalues := make(map[string]configFile)
+	filevalues["hosts"] = configFile{
+		subDirs:  "",
+		contents: "a.example.com\nb.example.com\nc.example.com\n",
+	}
+
+	dir, err := createFilesInTempDir(filevalues)
+	if err != nil {
+		t.Errorf("Could not create files in temp dir: %v", err)
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	type Config struct {
+		Hosts []string `env:"SEPARATOR_HOSTS" filesep:"\n" envsep:","`
+	}
+
+	expected := []string{"a.example.com", "b.example.com", "c.example.com"}
+	tables := []struct {
+		dir string
+		env string
+	}{
+		{dir, ""},
+		{"", "a.example.com, b.example.com,c.example.com"},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags([]string{})
+		if table.env == "" {
+			os.Unsetenv("SEPARATOR_HOSTS")
+		} else {
+			os.Setenv("SEPARATOR_HOSTS", table.env)
+		}
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		result := Config{}
+		if err := ParseWithDir(&result, table.dir); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if !reflect.DeepEqual(result.Hosts, expected) {
+			t.Errorf("Expected %q but got %q instead", expected, result.Hosts)
+		}
+	}
+	os.Unsetenv("SEPARATOR_HOSTS")
+
+	// The filesep and envsep tags only apply to slices and maps.
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	invalid := struct {
+		Host string `filesep:"\n"`
+	}{}
+	if err := Parse(&invalid); err == nil {
+		t.Error("Expected an error for a filesep tag on a string but did not get it")
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestParseDeterministic(t *testing.T) {
+	filevalues := make(map[string]configFile)
+	filevalues["username"] = configFile{
+		subDirs:  "",
+		contents: "fileuser",
+	}
+
+	dir, err := createFilesInTempDir(filevalues)
+	if err != nil {
+		t.Errorf("Could not create files in temp dir: %v", err)
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	type Config struct {
+		Username string
+		Hostname string   `env:"HOST" flag:"host" default:"localhost"`
+		Port     int      `env:"PORT" default:"8080"`
+		Async    bool     `env:"ASYNC"`
+		Servers  []string `envindexed:"SERVER"`
+	}
+
+	// The process environment and command line must not be consulted.
+	setFlags([]string{"-host", "processflag"})
+	setConfigEnv([]string{"processenv", "1", "true"})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	tables := []struct {
+		dir      string
+		env      map[string]string
+		args     []string
+		expected Config
+		isErr    bool
+	}{
+		{"", nil, nil, Config{"", "localhost", 8080, false, nil}, false},
+		{dir, map[string]string{"PORT": "9090", "SERVER_0": "a", "SERVER_1": "b"}, []string{"-host", "flaghost"}, Config{"fileuser", "flaghost", 9090, false, []string{"a", "b"}}, false},
+		{"", map[string]string{"HOST": "envhost", "ASYNC": "true"}, []string{"-host", "flaghost"}, Config{"", "envhost", 8080, true, nil}, false},
+		{"", nil, []string{"-unknown"}, Config{}, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+
+		result := Config{}
+		err := ParseDeterministic(&result, table.dir, table.env, table.args)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if !reflect.DeepEqual(result, table.expected) {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+
+	if flag.CommandLine.Lookup("host") != nil {
+		t.Error("Expected no flags to be registered with flag.CommandLine")
+	}
+	setConfigEnv([]string{"", "", ""})
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestDebugMultipleSources(t *testing.T) {
+	filevalues := make(map[string]configFile)
+	filevalues["debugport"] = configFile{
+		subDirs:  "",
+		contents: "7070",
+	}
+
+	dir, err := createFilesInTempDir(filevalues)
+	if err != nil {
+		t.Errorf("Could not create files in temp dir: %v", err)
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	type Config struct {
+		DebugPort int    `env:"DEBUG_PORT" default:"8080"`
+		DebugHost string `env:"DEBUG_HOST" default:"localhost"`
+		DebugName string `env:"DEBUG_NAME" default:"web"`
+	}
+
+	// DebugPort is set from the file even though its environment variable
+	// is also set, and both DebugPort and DebugHost were set by command line
+	// flags before being overridden.
+	os.Setenv("DEBUG_PORT", "6060")
+	os.Setenv("DEBUG_HOST", "envhost")
+	os.Unsetenv("DEBUG_NAME")
+	defer os.Unsetenv("DEBUG_PORT")
+	defer os.Unsetenv("DEBUG_HOST")
+
+	for _, debug := range []bool{false, true} {
+		t.Logf("Testing with DebugMultipleSources %v", debug)
+		setFlags([]string{"-debugport", "5050", "-debughost", "flaghost"})
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		var buf bytes.Buffer
+		opts := Options{Logger: log.New(&buf, "", 0), DebugMultipleSources: debug}
+		result := Config{}
+		if err := ParseWithOptions(&result, dir, opts); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		expected := Config{7070, "envhost", "web"}
+		if result != expected {
+			t.Errorf("Expected %+v but got %+v instead", expected, result)
+		}
+
+		logged := buf.String()
+		if !debug {
+			if logged != "" {
+				t.Errorf("Expected nothing to be logged but got %q", logged)
+			}
+			continue
+		}
+		for _, want := range []string{
+			"field DebugPort was assigned from more than one source: command line flag debugport, then file debugport",
+			"field DebugHost was assigned from more than one source: command line flag debughost, then environment variable DEBUG_HOST",
+		} {
+			if !strings.Contains(logged, want) {
+				t.Errorf("Expected the log to contain %q but got %q", want, logged)
+			}
+		}
+		if strings.Contains(logged, "DebugName") {
+			t.Errorf("Expected DebugName, which was only set by its default, not to be logged but got %q", logged)
+		}
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestOnComplete(t *testing.T) {
+	type Config struct {
+		Port int `noenv:"true" mandatory:"true"`
+	}
+
+	tables := []struct {
+		flags []string
+		isErr bool
+	}{
+		{[]string{"-port", "8080"}, false},
+		{[]string{}, true},
+		{[]string{"-port", "http"}, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		stderr := new(bytes.Buffer)
+		flag.CommandLine.SetOutput(stderr)
+
+		calls := 0
+		var completeErr error
+		opts := Options{OnComplete: func(err error) {
+			calls++
+			completeErr = err
+		}}
+		result := Config{}
+		err := ParseWithOptions(&result, "", opts)
+		if calls != 1 {
+			t.Errorf("Expected OnComplete to be called once but it was called %d times", calls)
+		}
+		if completeErr != err {
+			t.Errorf("Expected OnComplete to receive %v but got %v instead", err, completeErr)
+		}
+		if table.isErr != (err != nil) {
+			t.Errorf("Unexpected error value: %v", err)
+		}
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+type level int
+
+const (
+	levelDebug level = iota
+	levelInfo
+	levelWarn
+)
+
+func TestEnumMaps(t *testing.T) {
+	type Config struct {
+		Level  level   `noenv:"true" default:"info"`
+		Levels []level `noenv:"true"`
+	}
+
+	levels := map[string]int{"debug": int(levelDebug), "info": int(levelInfo), "warn": int(levelWarn)}
+	opts := Options{EnumMaps: map[string]map[string]int{"Level": levels, "Levels": levels}}
+
+	tables := []struct {
+		flags    []string
+		expected Config
+		isErr    bool
+	}{
+		{[]string{}, Config{levelInfo, nil}, false},
+		{[]string{"-level", "warn", "-levels", "debug,WARN"}, Config{levelWarn, []level{levelDebug, levelWarn}}, false},
+		{[]string{"-level", "trace"}, Config{}, true},
+		{[]string{"-level", "1"}, Config{}, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		stderr := new(bytes.Buffer)
+		flag.CommandLine.SetOutput(stderr)
+
+		result := Config{}
+		err := ParseWithOptions(&result, "", opts)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else if !strings.Contains(err.Error(), "debug, info, warn") {
+				t.Errorf("Expected the error to list the valid names but got: %v", err)
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if !reflect.DeepEqual(result, table.expected) {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+
+	// The usage text shows the default by name.
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	if err := ParseWithOptions(&Config{}, "", opts); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if f := flag.CommandLine.Lookup("level"); f == nil || f.DefValue != "info" {
+		t.Errorf("Expected the level flag to have a default of info but got %+v", f)
+	}
+
+	// Enum maps must refer to int fields which exist.
+	for _, invalid := range []map[string]map[string]int{
+		{"Level": levels, "Missing": levels},
+		{"Name": levels},
+	} {
+		setFlags([]string{})
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		config := struct {
+			Level level  `noenv:"true"`
+			Name  string `noenv:"true"`
+		}{}
+		if err := ParseWithOptions(&config, "", Options{EnumMaps: invalid}); err == nil {
+			t.Errorf("Expected an error for %v but did not get it", invalid)
+		}
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestUnits(t *testing.T) {
+	type Config struct {
+		Delay  int       `noenv:"true" unit:"ms" default:"100ms"`
+		Ratio  float64   `noenv:"true" unit:"s"`
+		Weight int       `noenv:"true" unit:"kg"`
+		Sizes  []float64 `noenv:"true" unit:"MB"`
+	}
+
+	tables := []struct {
+		flags    []string
+		expected Config
+		isErr    bool
+	}{
+		{[]string{}, Config{Delay: 100}, false},
+		{[]string{"-delay", "500ms"}, Config{Delay: 500}, false},
+		{[]string{"-delay", "2s", "-ratio", "1500ms"}, Config{Delay: 2000, Ratio: 1.5}, false},
+		{[]string{"-weight", "70kg", "-sizes", "1.5MB,20MB"}, Config{Delay: 100, Weight: 70, Sizes: []float64{1.5, 20}}, false},
+		{[]string{"-delay", "500"}, Config{}, true},
+		{[]string{"-delay", "1500us"}, Config{}, true},
+		{[]string{"-weight", "70lb"}, Config{}, true},
+		{[]string{"-sizes", "1MB,2"}, Config{}, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		stderr := new(bytes.Buffer)
+		flag.CommandLine.SetOutput(stderr)
+
+		result := Config{}
+		err := Parse(&result)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if !reflect.DeepEqual(result, table.expected) {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+
+	invalid := struct {
+		Name string `unit:"ms"`
+	}{}
+	if err := Parse(&invalid); err == nil {
+		t.Error("Expected an error for a unit tag on a string field but did not get it")
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestFreeze(t *testing.T) {
+	type Config struct {
+		Host string `noenv:"true"`
+		Port int    `noenv:"true"`
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	verbose := flag.Bool("verbose", false, "verbose output")
+
+	setFlags([]string{"-host", "example.com", "-port", "80"})
+	result := Config{}
+	if err := Parse(&result); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	Freeze()
+
+	if flag.Lookup("host") != nil || flag.Lookup("port") != nil {
+		t.Error("Expected the struct's flags to be removed but they were still registered")
+	}
+	if flag.Lookup("verbose") == nil {
+		t.Error("Expected the verbose flag to be kept but it was removed")
+	}
+
+	stderr := new(bytes.Buffer)
+	flag.CommandLine.SetOutput(stderr)
+	if err := flag.CommandLine.Parse([]string{"-verbose"}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !*verbose {
+		t.Error("Expected the verbose flag to be set but it was not")
+	}
+	if err := flag.CommandLine.Parse([]string{"-host", "other.com", "-port", "8080"}); err == nil {
+		t.Error("Expected an error for a frozen flag but did not get it")
+	}
+	expected := Config{"example.com", 80}
+	if result != expected {
+		t.Errorf("Expected %+v but got %+v instead", expected, result)
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestParseWithDirs(t *testing.T) {
+	base, err := createFilesInTempDir(map[string]configFile{
+		"username": {contents: "admin"},
+		"port":     {contents: "1000"},
+		"cert":     {subDirs: "tls", contents: "base cert"},
+	})
+	if err != nil {
+		t.Errorf("Could not create files in temp dir: %v", err)
+		return
+	}
+	defer os.RemoveAll(base)
+
+	override, err := createFilesInTempDir(map[string]configFile{
+		"port":     {subDirs: "conf", contents: "2000"},
+		"hostname": {contents: "filehost"},
+	})
+	if err != nil {
+		t.Errorf("Could not create files in temp dir: %v", err)
+		return
+	}
+	defer os.RemoveAll(override)
+
+	type Config struct {
+		Username string `noenv:"true" noflag:"true"`
+		Port     int    `noenv:"true" noflag:"true"`
+		Cert     string `file:"tls/cert" noenv:"true" noflag:"true"`
+		Hostname string `env:"HOST" sources:"env,file"`
+		Timeout  int    `noenv:"true" noflag:"true" default:"30"`
+	}
+
+	setFlags([]string{})
+	setConfigEnv([]string{"envhost", "", ""})
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	result := Config{}
+	var parseResult ParseResult
+	if err := NewParser().WithOptions(Options{Result: &parseResult}).WithDirs(base, override).Parse(&result); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := Config{"admin", 2000, "base cert", "envhost", 30}
+	if result != expected {
+		t.Errorf("Expected %+v but got %+v instead", expected, result)
+	}
+	expectedDirs := map[string]int{"Username": 0, "Port": 1, "Cert": 0}
+	if !reflect.DeepEqual(parseResult.FieldDirs, expectedDirs) {
+		t.Errorf("Expected field dirs %v but got %v instead", expectedDirs, parseResult.FieldDirs)
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	result = Config{}
+	if err := ParseWithDirs(&result, override, base); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Port != 1000 {
+		t.Errorf("Expected the port from the last directory, 1000, but got %d instead", result.Port)
+	}
+
+	// A name which occurs more than once within a directory resolves to
+	// the same file as with ParseWithDir.
+	nested, err := createFilesInTempDir(map[string]configFile{
+		"port": {contents: "1"},
+		"a":    {subDirs: "a", contents: "ignored"},
+		"z":    {subDirs: "z", contents: "ignored"},
+	})
+	if err != nil {
+		t.Fatalf("Could not create files in temp dir: %v", err)
+	}
+	defer os.RemoveAll(nested)
+	for i, sub := range []string{"a", "z"} {
+		if err := os.WriteFile(filepath.Join(nested, sub, "port"), []byte(strconv.Itoa(i+2)), 0644); err != nil {
+			t.Fatalf("Could not write file: %v", err)
+		}
+	}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	single := Config{}
+	if err := ParseWithDir(&single, nested); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	result = Config{}
+	if err := ParseWithDirs(&result, nested); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Port != single.Port {
+		t.Errorf("Expected the same port as ParseWithDir, %d, but got %d instead", single.Port, result.Port)
+	}
+
+	setConfigEnv([]string{"", "", ""})
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestCountFlags(t *testing.T) {
+	type Config struct {
+		Verbose int  `flag:"v" env:"VERBOSE" count:"true" default:"1"`
+		Debug   bool `noenv:"true"`
+	}
+
+	tables := []struct {
+		flags    []string
+		env      string
+		expected Config
+	}{
+		{[]string{}, "", Config{1, false}},
+		{[]string{"-v"}, "", Config{1, false}},
+		{[]string{"-v", "-v", "-v"}, "", Config{3, false}},
+		{[]string{"-v", "-debug", "-v"}, "", Config{2, true}},
+		{[]string{"-v=5", "-v"}, "", Config{6, false}},
+		{[]string{"-v", "-v", "-v"}, "0", Config{0, false}},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+		if table.env == "" {
+			os.Unsetenv("VERBOSE")
+		} else {
+			os.Setenv("VERBOSE", table.env)
+		}
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		result := Config{}
+		if err := Parse(&result); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+	os.Unsetenv("VERBOSE")
+
+	invalid := struct {
+		Verbose bool `count:"true"`
+	}{}
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	if err := Parse(&invalid); err == nil {
+		t.Error("Expected an error for a count tag on a bool field but did not get it")
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestParseWithProfile(t *testing.T) {
+	dir, err := createFilesInTempDir(map[string]configFile{
+		"hostname": {contents: "localhost"},
+		"port":     {contents: "8080"},
+		"username": {contents: "admin"},
+	})
+	if err != nil {
+		t.Errorf("Could not create files in temp dir: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "production"), 0755); err != nil {
+		t.Fatalf("Could not create profile dir: %v", err)
+	}
+	for name, contents := range map[string]string{
+		"production/hostname": "prod.example.com",
+		"production/port":     "443",
+		"staging/debug":       "true",
+		"tls/key":             "secret",
+	} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755); err != nil {
+			t.Fatalf("Could not create dir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("Could not write file: %v", err)
+		}
+	}
+
+	type Config struct {
+		Hostname string `noenv:"true" noflag:"true"`
+		Port     int    `noenv:"true" noflag:"true"`
+		Username string `noenv:"true" noflag:"true"`
+		Debug    bool   `noenv:"true" noflag:"true"`
+		Key      string `noenv:"true" noflag:"true" file:"tls/key"`
+	}
+
+	// Another profile's files are never used.
+	tables := []struct {
+		profile  string
+		expected Config
+	}{
+		{"production", Config{"prod.example.com", 443, "admin", false, "secret"}},
+		{"staging", Config{"localhost", 8080, "admin", true, "secret"}},
+		{"", Config{"localhost", 8080, "admin", false, "secret"}},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags([]string{})
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		result := Config{}
+		if err := ParseWithProfile(&result, dir, table.profile); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestValueTransform(t *testing.T) {
+	dir, err := createFilesInTempDir(map[string]configFile{
+		"hostname": {contents: "<localhost>"},
+		"ready":    {contents: "anything"},
+	})
+	if err != nil {
+		t.Errorf("Could not create files in temp dir: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	type Config struct {
+		Hostname string   `noenv:"true" noflag:"true"`
+		Username string   `noflag:"true"`
+		Port     int      `noenv:"true"`
+		Servers  []string `noflag:"true" envindexed:"SERVER"`
+		Region   string   `noenv:"true" noflag:"true" default:"eu"`
+		Ready    bool     `fileexists:"true" noenv:"true" noflag:"true"`
+	}
+
+	unwrap := func(field, raw string) (string, error) {
+		if !strings.HasPrefix(raw, "<") || !strings.HasSuffix(raw, ">") {
+			return "", fmt.Errorf("value %q is not wrapped", raw)
+		}
+		return raw[1 : len(raw)-1], nil
+	}
+
+	tables := []struct {
+		env      map[string]string
+		args     []string
+		expected Config
+		isErr    bool
+	}{
+		{map[string]string{"USERNAME": "<admin>", "SERVER_0": "<a>", "SERVER_1": "<b>"}, []string{"-port", "<8080>"}, Config{"localhost", "admin", 8080, []string{"a", "b"}, "eu", true}, false},
+		{map[string]string{"USERNAME": "admin"}, []string{}, Config{}, true},
+		{map[string]string{}, []string{"-port", "8080"}, Config{}, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		pr := deterministicParser(dir, table.env, table.args, Options{ValueTransform: unwrap})
+
+		result := Config{}
+		err := pr.Parse(&result)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if !reflect.DeepEqual(result, table.expected) {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+}
+
+func TestEncrypted(t *testing.T) {
+	type Config struct {
+		Password string `encrypted:"true" noflag:"true"`
+		Port     int    `encrypted:"true" default:"8080"`
+		Username string `noflag:"true"`
+	}
+
+	decrypt := func(ciphertext string) (string, error) {
+		plaintext, err := base64.StdEncoding.DecodeString(ciphertext)
+		return string(plaintext), err
+	}
+	encrypt := func(plaintext string) string {
+		return base64.StdEncoding.EncodeToString([]byte(plaintext))
+	}
+
+	tables := []struct {
+		env      map[string]string
+		args     []string
+		opts     Options
+		expected Config
+		isErr    bool
+	}{
+		{map[string]string{"PASSWORD": encrypt("s3cret"), "USERNAME": "admin"}, []string{}, Options{Decryptor: decrypt}, Config{"s3cret", 8080, "admin"}, false},
+		{map[string]string{}, []string{"-port", encrypt("9090")}, Options{Decryptor: decrypt}, Config{"", 9090, ""}, false},
+		{map[string]string{"PASSWORD": "s3cret!"}, []string{}, Options{Decryptor: decrypt}, Config{}, true},
+		{map[string]string{}, []string{}, Options{}, Config{}, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		pr := deterministicParser("", table.env, table.args, table.opts)
+
+		result := Config{}
+		err := pr.Parse(&result)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+}
+
+func TestSizedIntegers(t *testing.T) {
+	type Config struct {
+		MaxBytes  int64    `default:"1048576"`
+		Offset    int8     `noflag:"true"`
+		Ports     uint     `max:"100"`
+		Checksum  uint32   `noflag:"true"`
+		Timestamp uint64   `noflag:"true"`
+		Weights   []uint16 `noflag:"true"`
+	}
+
+	tables := []struct {
+		env      map[string]string
+		args     []string
+		expected Config
+		isErr    bool
+	}{
+		{map[string]string{}, []string{}, Config{MaxBytes: 1048576}, false},
+		{map[string]string{"OFFSET": "-128", "CHECKSUM": "4294967295", "TIMESTAMP": "18446744073709551615", "WEIGHTS": "1,2,65535"}, []string{"-maxbytes", "9223372036854775807", "-ports", "3"}, Config{9223372036854775807, -128, 3, 4294967295, 18446744073709551615, []uint16{1, 2, 65535}}, false},
+		{map[string]string{"OFFSET": "128"}, []string{}, Config{}, true},
+		{map[string]string{"CHECKSUM": "-1"}, []string{}, Config{}, true},
+		{map[string]string{"WEIGHTS": "1,65536"}, []string{}, Config{}, true},
+		{map[string]string{}, []string{"-ports", "-3"}, Config{}, true},
+		{map[string]string{}, []string{"-ports", "101"}, Config{}, true},
+		{map[string]string{}, []string{"-maxbytes", "1.5"}, Config{}, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		pr := deterministicParser("", table.env, table.args, Options{})
+		pr.opts.flagSet.SetOutput(new(bytes.Buffer))
+
+		result := Config{}
+		err := pr.Parse(&result)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if !reflect.DeepEqual(result, table.expected) {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+		if f := pr.opts.flagSet.Lookup("maxbytes"); f == nil || f.DefValue != "1048576" {
+			t.Errorf("Expected the maxbytes flag to have a default of 1048576 but got %v", f)
+		}
+	}
+}
+
+func TestRequirePrefix(t *testing.T) {
+	type Config struct {
+		Password string   `requireprefix:"secret://" noflag:"true"`
+		Token    string   `requireprefix:"secret://" default:"none"`
+		Keys     []string `requireprefix:"secret://" noflag:"true" envindexed:"KEY"`
+	}
+
+	tables := []struct {
+		env      map[string]string
+		args     []string
+		expected Config
+		isErr    bool
+	}{
+		{map[string]string{"PASSWORD": "secret://s3cret", "KEY_0": "secret://a", "KEY_1": "secret://b"}, []string{"-token", "secret://abc"}, Config{"s3cret", "abc", []string{"a", "b"}}, false},
+		{map[string]string{"PASSWORD": "secret://"}, []string{}, Config{"", "none", nil}, false},
+		{map[string]string{"PASSWORD": "s3cret"}, []string{}, Config{}, true},
+		{map[string]string{}, []string{"-token", "abc"}, Config{}, true},
+		{map[string]string{"KEY_0": "secret://a", "KEY_1": "b"}, []string{}, Config{}, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		pr := deterministicParser("", table.env, table.args, Options{})
+		pr.opts.flagSet.SetOutput(new(bytes.Buffer))
+
+		result := Config{}
+		err := pr.Parse(&result)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if !reflect.DeepEqual(result, table.expected) {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+}
+
+func TestSetFields(t *testing.T) {
+	type Config struct {
+		Set   map[string]struct{} `env:"ROLES" flag:"roles"`
+		Ports map[int]struct{}    `separator:";"`
+	}
+
+	tables := []struct {
+		env   map[string]string
+		args  []string
+		roles []string
+		ports []int
+		isErr bool
+	}{
+		{map[string]string{"ROLES": "admin, ops,admin"}, []string{"-ports", "80;443;80"}, []string{"admin", "ops"}, []int{80, 443}, false},
+		{map[string]string{}, []string{"-roles", "viewer"}, []string{"viewer"}, nil, false},
+		{map[string]string{"ROLES": ""}, []string{}, []string{}, nil, false},
+		{map[string]string{}, []string{"-ports", "80;http"}, nil, nil, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		pr := deterministicParser("", table.env, table.args, Options{})
+		pr.opts.flagSet.SetOutput(new(bytes.Buffer))
+
+		result := Config{}
+		err := pr.Parse(&result)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if len(result.Set) != len(table.roles) {
+			t.Errorf("Expected %d members but got %v instead", len(table.roles), result.Set)
+		}
+		for _, role := range table.roles {
+			if _, ok := result.Set[role]; !ok {
+				t.Errorf("Expected %q to be a member of %v", role, result.Set)
+			}
+		}
+		if len(result.Ports) != len(table.ports) {
+			t.Errorf("Expected %d members but got %v instead", len(table.ports), result.Ports)
+		}
+		for _, port := range table.ports {
+			if _, ok := result.Ports[port]; !ok {
+				t.Errorf("Expected %d to be a member of %v", port, result.Ports)
+			}
+		}
+	}
+
+	p := valueParam(reflect.StructField{Name: "Set", Type: reflect.TypeOf(map[string]struct{}{})}, reflect.ValueOf(&struct{ Set map[string]struct{} }{map[string]struct{}{"ops": {}, "admin": {}}}).Elem().Field(0), nil)
+	if s := p.String(); s != "admin,ops" {
+		t.Errorf("Expected admin,ops but got %q instead", s)
+	}
+}
+
+func TestParseWithFlagSet(t *testing.T) {
+	type Config struct {
+		Host string `noenv:"true" default:"localhost"`
+		Port int    `noenv:"true"`
+	}
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	tables := []struct {
+		args     []string
+		expected Config
+	}{
+		{[]string{"-host", "example.com", "-port", "8080"}, Config{"example.com", 8080}},
+		{[]string{"-port", "9090"}, Config{"localhost", 9090}},
+		{nil, Config{"localhost", 0}},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		result := Config{}
+		if err := ParseWithFlagSet(&result, "", fs, table.args); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+		if fs.Lookup("port") == nil {
+			t.Error("Expected the port flag to be registered with the flag set")
+		}
+	}
+
+	if flag.CommandLine.Lookup("port") != nil {
+		t.Error("Expected flag.CommandLine to be left alone")
+	}
+
+	// The flag set is kept when the options are replaced.
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	result := Config{}
+	if err := NewParser().WithFlagSet(fs, []string{"-port", "80"}).WithOptions(Options{}).Parse(&result); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	} else if result.Port != 80 {
+		t.Errorf("Expected port 80 but got %d instead", result.Port)
+	}
+}
+
+func TestConcurrentParse(t *testing.T) {
+	type Config struct {
+		Host string `default:"localhost"`
+		Port int    `mandatory:"true"`
+		Tags []string
+	}
+
+	// Run with -race to detect parses sharing state.
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	results := make([]Config, len(errs))
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			env := map[string]string{"PORT": strconv.Itoa(8000 + i)}
+			args := []string{"-host", fmt.Sprintf("host%d", i), "-tags", "a,b"}
+			errs[i] = ParseDeterministic(&results[i], "", env, args)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Unexpected error in parse %d: %v", i, err)
+			continue
+		}
+		expected := Config{fmt.Sprintf("host%d", i), 8000 + i, []string{"a", "b"}}
+		if !reflect.DeepEqual(results[i], expected) {
+			t.Errorf("Expected %+v but got %+v instead", expected, results[i])
+		}
+	}
+}
+
+func TestFileTrailingNewline(t *testing.T) {
+	dir, err := createFilesInTempDir(map[string]configFile{
+		"password": {contents: "mypassword\n"},
+		"username": {contents: "admin\r\n"},
+		"port":     {contents: "8080\n"},
+		"debug":    {contents: "true\n"},
+		"motd":     {contents: "hello \n\n"},
+		"cert":     {contents: "line1\nline2\n"},
+	})
+	if err != nil {
+		t.Errorf("Could not create files in temp dir: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	type Config struct {
+		Password string `noenv:"true" noflag:"true"`
+		Username string `noenv:"true" noflag:"true"`
+		Port     int    `noenv:"true" noflag:"true"`
+		Debug    bool   `noenv:"true" noflag:"true"`
+		Motd     string `noenv:"true" noflag:"true"`
+		Cert     string `noenv:"true" noflag:"true"`
+	}
+
+	tables := []struct {
+		opts     Options
+		expected Config
+	}{
+		{Options{}, Config{"mypassword", "admin", 8080, true, "hello \n", "line1\nline2"}},
+		{Options{TrimFileWhitespace: true}, Config{"mypassword", "admin", 8080, true, "hello", "line1\nline2"}},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		pr := deterministicParser(dir, nil, nil, table.opts)
+
+		result := Config{}
+		if err := pr.Parse(&result); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+}
+
+func TestSecretRedaction(t *testing.T) {
+	type Config struct {
+		Password string   `secret:"true" default:"hunter2" usage:"database password"`
+		APIKey   string   `secret:"true" pattern:"key-[0-9]+"`
+		Tokens   []string `secret:"true"`
+		Host     string   `default:"localhost"`
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	usage := new(bytes.Buffer)
+	fs.SetOutput(usage)
+	result := Config{}
+	if err := ParseWithFlagSet(&result, "", fs, []string{"-apikey", "key-123", "-tokens", "a,b"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := (Config{"hunter2", "key-123", []string{"a", "b"}, "localhost"}); !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %+v but got %+v instead", expected, result)
+	}
+
+	fs.PrintDefaults()
+	if strings.Contains(usage.String(), "hunter2") {
+		t.Errorf("Expected the usage text to mask the secret but got: %v", usage.String())
+	}
+	if !strings.Contains(usage.String(), `(default ****)`) || !strings.Contains(usage.String(), `(default localhost)`) {
+		t.Errorf("Expected the usage text to show a masked default but got: %v", usage.String())
+	}
+	for _, name := range []string{"password", "apikey", "tokens"} {
+		if val := fs.Lookup(name).Value.String(); val != "****" {
+			t.Errorf("Expected flag %s to be masked but got %q", name, val)
+		}
+	}
+
+	// Errors don't show the value either.
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(new(bytes.Buffer))
+	result = Config{}
+	err := ParseWithFlagSet(&result, "", fs, []string{"-apikey", "hunter3"})
+	if err == nil {
+		t.Fatal("Expected an error but did not get it")
+	}
+	if strings.Contains(err.Error(), "hunter3") {
+		t.Errorf("Expected the error to mask the secret but got: %v", err)
+	}
+
+	// Nor do errors for values which can't be parsed.
+	pr := deterministicParser("", map[string]string{"PIN": "12x34"}, nil, Options{})
+	pin := struct {
+		Pin int `secret:"true"`
+	}{}
+	err = pr.Parse(&pin)
+	if err == nil {
+		t.Fatal("Expected an error but did not get it")
+	}
+	if strings.Contains(err.Error(), "12x34") {
+		t.Errorf("Expected the error to mask the secret but got: %v", err)
+	}
+}
+
+func TestOnUnknownField(t *testing.T) {
+	type Config struct {
+		Name     string
+		Callback func()
+		Channel  chan int
+	}
+
+	errChannel := errors.New("channels are not allowed")
+	var seen []string
+	onUnknown := func(fieldName string, kind reflect.Kind) error {
+		seen = append(seen, fieldName)
+		if kind == reflect.Chan {
+			return errChannel
+		}
+		return nil
+	}
+
+	tables := []struct {
+		opts  Options
+		isErr bool
+		seen  []string
+	}{
+		{Options{}, false, nil},
+		{Options{OnUnknownField: onUnknown}, false, nil},
+		{Options{StrictTypes: true}, true, nil},
+		{Options{StrictTypes: true, OnUnknownField: onUnknown}, true, []string{"Callback", "Channel"}},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		seen = nil
+		pr := deterministicParser("", nil, []string{"-name", "web"}, table.opts)
+
+		result := Config{}
+		err := pr.Parse(&result)
+		if !reflect.DeepEqual(seen, table.seen) {
+			t.Errorf("Expected OnUnknownField to be called for %v but got %v instead", table.seen, seen)
+		}
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			if table.opts.OnUnknownField != nil && !errors.Is(err, errChannel) {
+				t.Errorf("Expected the error returned by OnUnknownField but got %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result.Name != "web" {
+			t.Errorf("Expected Name to be web but got %q instead", result.Name)
+		}
+	}
+}
+
+func TestPointerFields(t *testing.T) {
+	type Config struct {
+		Port    *int
+		Host    *string
+		Debug   *bool
+		Timeout *int `default:"30"`
+	}
+
+	intPtr := func(i int) *int { return &i }
+	stringPtr := func(s string) *string { return &s }
+	boolPtr := func(b bool) *bool { return &b }
+
+	tables := []struct {
+		env      map[string]string
+		args     []string
+		expected Config
+	}{
+		{map[string]string{}, []string{}, Config{nil, nil, nil, intPtr(30)}},
+		{map[string]string{"PORT": "0", "HOST": ""}, []string{}, Config{intPtr(0), stringPtr(""), nil, intPtr(30)}},
+		{map[string]string{}, []string{"-port", "8080", "-debug", "-timeout", "0"}, Config{intPtr(8080), nil, boolPtr(true), intPtr(0)}},
+		{map[string]string{"DEBUG": "false"}, []string{"-host", "localhost"}, Config{nil, stringPtr("localhost"), boolPtr(false), intPtr(30)}},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		pr := deterministicParser("", table.env, table.args, Options{})
+
+		result := Config{}
+		if err := pr.Parse(&result); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if !reflect.DeepEqual(result, table.expected) {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+
+	// A value the field already pointed to is not overwritten.
+	previous := 1
+	pr := deterministicParser("", map[string]string{"PORT": "2"}, nil, Options{})
+	result := Config{Port: &previous}
+	if err := pr.Parse(&result); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if previous != 1 || result.Port == nil || *result.Port != 2 {
+		t.Errorf("Expected a new value of 2 leaving the previous value of 1 alone but got %v and %v", result.Port, previous)
+	}
+}
+
+func TestPrintDefaults(t *testing.T) {
+	type Config struct {
+		Port int `default:"8080" usage:"port to listen on"`
+		Key  [4]byte
+		Name string
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	usage := new(bytes.Buffer)
+	fs.SetOutput(usage)
+	if err := ParseWithFlagSet(&Config{}, "", fs, []string{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	fs.PrintDefaults()
+	if strings.Contains(usage.String(), "panic") {
+		t.Errorf("Expected the usage text not to contain a panic but got: %v", usage.String())
+	}
+	if !strings.Contains(usage.String(), "(default 8080)") {
+		t.Errorf("Expected the usage text to show the default but got: %v", usage.String())
+	}
+}
+
+func TestParseAndClose(t *testing.T) {
+	type Config struct {
+		Hostname string `env:"HOST" flag:"host" default:"localhost"`
+		Port     int    `noenv:"true" default:"8080"`
+	}
+
+	setFlags([]string{"-host", "flaghost", "-port", "9090"})
+	setConfigEnv([]string{"", "", ""})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	stderr := new(bytes.Buffer)
+	flag.CommandLine.SetOutput(stderr)
+
+	// Without the reset in between, the second call would panic when it
+	// registers the same flags again.
+	for i := 0; i < 2; i++ {
+		result := Config{}
+		if err := ParseAndClose(&result, ""); err != nil {
+			t.Fatalf("Unexpected error on call %d: %v", i, err)
+		}
+		expected := Config{"flaghost", 9090}
+		if result != expected {
+			t.Errorf("Expected %+v but got %+v instead on call %d", expected, result, i)
+		}
+		if flag.CommandLine.Lookup("host") != nil {
+			t.Errorf("Expected the host flag to be discarded after call %d", i)
+		}
+		if flag.CommandLine.ErrorHandling() != flag.ContinueOnError || flag.CommandLine.Output() != stderr {
+			t.Errorf("Expected the new flag set to keep the old one's configuration after call %d", i)
+		}
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+// deterministicParser returns a parser for dir with opts which, like
+// ParseDeterministic, looks up environment variables in env and parses the
+// command line flags from args with a flag set of its own. A nil env or args
+// is treated as empty.
+func deterministicParser(dir string, env map[string]string, args []string, opts Options) *Parser {
+	if env == nil {
+		env = map[string]string{}
+	}
+	if args == nil {
+		args = []string{}
+	}
+	pr := NewParser().WithDir(dir).WithOptions(opts)
+	pr.opts.env = env
+	pr.opts.args = args
+	pr.opts.flagSet = flag.NewFlagSet("", flag.ContinueOnError)
+	return pr
+}
+
 func setFlags(args []string) {
 	myargs := []string{"test"}
 	myargs = append(myargs, args...)
@@ -0,0 +1,116 @@
+package configparser
+
+import (
+	"flag"
+	"io/fs"
+	"log"
+)
+
+// Parser holds the configuration used to parse structs. Use NewParser to
+// create a Parser and its With methods to configure it, e.g.
+//
+//	err := NewParser().WithDir("/config").WithEnvPrefix("MYAPP_").Parse(&c)
+//
+// The With methods modify the Parser they are called on and return it, so
+// they can be chained.
+type Parser struct {
+	dir        string
+	dirs       []string
+	dirEnvKey  string
+	dirFlagKey string
+	fileMap    map[string]string
+	fsys       fs.FS
+	opts       Options
+}
+
+// NewParser returns a Parser which behaves like Parse until it is configured
+// otherwise.
+func NewParser() *Parser {
+	return &Parser{}
+}
+
+// WithDir sets the config directory which is walked to find files. See
+// ParseWithDir.
+func (pr *Parser) WithDir(dir string) *Parser {
+	pr.dir = dir
+	return pr
+}
+
+// WithDirs sets several config directories which are walked to find files,
+// in order of increasing precedence. See ParseWithDirs.
+func (pr *Parser) WithDirs(dirs ...string) *Parser {
+	pr.dirs = dirs
+	return pr
+}
+
+// WithConfigDirectory makes the parser retrieve the config directory from the
+// environment variable envKey or the command line flag flagKey, falling back
+// to defaultval, in that order. Either key may be empty if that source isn't
+// wanted.
+//
+// Unlike RetrieveConfigDirectory, the flag is registered alongside the
+// struct's flags and the command line is only parsed once, so the flag shows
+// up in the usage text. The directory is walked after the command line has
+// been parsed.
+func (pr *Parser) WithConfigDirectory(envKey, flagKey, defaultval string) *Parser {
+	pr.dir = defaultval
+	pr.dirEnvKey = envKey
+	pr.dirFlagKey = flagKey
+	return pr
+}
+
+// WithFileMap sets the files which are used instead of walking a config
+// directory. See ParseWithFileMap.
+func (pr *Parser) WithFileMap(fileMap map[string]string) *Parser {
+	pr.fileMap = fileMap
+	return pr
+}
+
+// WithFS makes the parser read config files from fsys instead of a config
+// directory on disk, e.g. from an embed.FS. fsys is searched in the same way
+// as a config directory: files are found by name in any of its
+// subdirectories, and file tags containing forward slashes are paths relative
+// to its root. The config directory and file map are ignored. The relfile and
+// filepath tags still refer to files on disk.
+func (pr *Parser) WithFS(fsys fs.FS) *Parser {
+	pr.fsys = fsys
+	return pr
+}
+
+// WithOptions replaces all of the parser's options with opts. The flag set
+// and arguments from WithFlagSet are kept.
+func (pr *Parser) WithOptions(opts Options) *Parser {
+	opts.flagSet, opts.args = pr.opts.flagSet, pr.opts.args
+	pr.opts = opts
+	return pr
+}
+
+// WithFlagSet makes the parser register the command line flags with fs and
+// parse them from args, instead of flag.CommandLine and os.Args[1:]. See
+// ParseWithFlagSet.
+func (pr *Parser) WithFlagSet(fs *flag.FlagSet, args []string) *Parser {
+	if args == nil {
+		args = []string{}
+	}
+	pr.opts.flagSet = fs
+	pr.opts.args = args
+	return pr
+}
+
+// WithEnvPrefix sets Options.EnvPrefix.
+func (pr *Parser) WithEnvPrefix(prefix string) *Parser {
+	pr.opts.EnvPrefix = prefix
+	return pr
+}
+
+// WithLogger sets Options.Logger.
+func (pr *Parser) WithLogger(logger *log.Logger) *Parser {
+	pr.opts.Logger = logger
+	return pr
+}
+
+// Parse sets the fields in the struct pointed to by ptrtostruct, as described
+// in ParseWithDir.
+func (pr *Parser) Parse(ptrtostruct interface{}) error {
+	return pr.parse(ptrtostruct, nil)
+}
@@ -0,0 +1,358 @@
+package configparser
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestParser(t *testing.T) {
+	filevalues := make(map[string]configFile)
+	filevalues["username"] = configFile{
+		subDirs:  "",
+		contents: "admin",
+	}
+
+	dir, err := createFilesInTempDir(filevalues)
+	if err != nil {
+		t.Errorf("Could not create files in temp dir: %v", err)
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	config := struct {
+		Username string
+		Port     int      `default:"8080"`
+		Servers  []string `envindexed:"SERVER"`
+		Ignored  complex128
+	}{}
+
+	os.Setenv("MYAPP_PORT", "9000")
+	os.Setenv("PORT", "7000")
+	os.Setenv("MYAPP_SERVER_0", "a.example.com")
+	defer os.Unsetenv("MYAPP_PORT")
+	defer os.Unsetenv("PORT")
+	defer os.Unsetenv("MYAPP_SERVER_0")
+
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	logs := new(bytes.Buffer)
+	err = NewParser().
+		WithDir(dir).
+		WithEnvPrefix("MYAPP_").
+		WithLogger(log.New(logs, "", 0)).
+		Parse(&config)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	if config.Username != "admin" {
+		t.Errorf("username was an unexpected value: %v", config.Username)
+	}
+	if config.Port != 9000 {
+		t.Errorf("port was an unexpected value: %v", config.Port)
+	}
+	if len(config.Servers) != 1 || config.Servers[0] != "a.example.com" {
+		t.Errorf("servers was an unexpected value: %v", config.Servers)
+	}
+	if !strings.Contains(logs.String(), "skipping field Ignored") {
+		t.Errorf("Expected the logger to be used - log output: %v", logs.String())
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestParserConfigDirectory(t *testing.T) {
+	filevalues := make(map[string]configFile)
+	filevalues["username"] = configFile{
+		subDirs:  "",
+		contents: "admin",
+	}
+
+	dir, err := createFilesInTempDir(filevalues)
+	if err != nil {
+		t.Errorf("Could not create files in temp dir: %v", err)
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	type Config struct {
+		Username string
+		Port     int
+	}
+
+	tables := []struct {
+		flags    []string
+		envdir   string
+		expected Config
+	}{
+		{[]string{"-configdir", dir, "-port", "9000"}, "", Config{"admin", 9000}}, // dir from flag
+		{[]string{"-port", "9000"}, dir, Config{"admin", 9000}},                   // dir from env
+		{[]string{"-configdir", "/doesnotexist"}, dir, Config{"admin", 0}},        // env takes precedence over flag
+		{[]string{"-username", "root"}, "", Config{"root", 0}},                    // default dir has no files
+	}
+
+	emptydir, err := createFilesInTempDir(map[string]configFile{})
+	if err != nil {
+		t.Errorf("Could not create files in temp dir: %v", err)
+		return
+	}
+	defer os.RemoveAll(emptydir)
+
+	os.Unsetenv("USERNAME")
+	os.Unsetenv("PORT")
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+		if table.envdir == "" {
+			os.Unsetenv("CONFIGDIR")
+		} else {
+			os.Setenv("CONFIGDIR", table.envdir)
+		}
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		stderr := new(bytes.Buffer)
+		flag.CommandLine.SetOutput(stderr)
+
+		result := Config{}
+		if err := NewParser().WithConfigDirectory("CONFIGDIR", "configdir", emptydir).Parse(&result); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+
+		// The config directory flag should be listed with the struct's
+		// flags.
+		flag.CommandLine.PrintDefaults()
+		if !strings.Contains(stderr.String(), "-configdir") || !strings.Contains(stderr.String(), "-username") {
+			t.Errorf("Expected usage to include -configdir and -username - got: %v", stderr.String())
+		}
+	}
+	os.Unsetenv("CONFIGDIR")
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestParseResultDir(t *testing.T) {
+	dir, err := createFilesInTempDir(map[string]configFile{})
+	if err != nil {
+		t.Errorf("Could not create files in temp dir: %v", err)
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	config := struct {
+		Username string
+	}{}
+
+	tables := []struct {
+		dir    string
+		exists bool
+		isErr  bool
+	}{
+		{dir, true, false},
+		{filepath.Join(dir, "doesnotexist"), false, true},
+		{"", false, false},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags([]string{})
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		result := ParseResult{Dir: "stale"}
+		err := ParseWithOptions(&config, table.dir, Options{Result: &result})
+		if table.isErr != (err != nil) {
+			t.Errorf("Unexpected error value: %v", err)
+		}
+		if result.Dir != table.dir {
+			t.Errorf("Expected dir %v but got %v instead", table.dir, result.Dir)
+		}
+		if result.DirExists != table.exists {
+			t.Errorf("Expected dir exists to be %v but got %v instead", table.exists, result.DirExists)
+		}
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestResolveDirRelativeToExe(t *testing.T) {
+	filevalues := make(map[string]configFile)
+	filevalues["username"] = configFile{
+		subDirs:  "config",
+		contents: "bundled",
+	}
+	exedir, err := createFilesInTempDir(filevalues)
+	if err != nil {
+		t.Errorf("Could not create files in temp dir: %v", err)
+		return
+	}
+
+	defer os.RemoveAll(exedir)
+
+	defer func(orig func() (string, error)) { executable = orig }(executable)
+	executable = func() (string, error) {
+		return filepath.Join(exedir, "myapp"), nil
+	}
+
+	type Config struct {
+		Username string `noenv:"true"`
+	}
+
+	tables := []struct {
+		dir      string
+		resolve  bool
+		expected string
+		isErr    bool
+	}{
+		{"config", true, "bundled", false},
+		{filepath.Join(exedir, "config"), true, "bundled", false}, // absolute dirs are left alone
+		{"config", false, "", true},                               // relative to the working directory, which has no config dir
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags([]string{})
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		config := Config{}
+		result := ParseResult{}
+		err := ParseWithOptions(&config, table.dir, Options{Result: &result, ResolveDirRelativeToExe: table.resolve})
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if config.Username != table.expected {
+			t.Errorf("Expected username %v but got %v instead", table.expected, config.Username)
+		}
+		if result.Dir != filepath.Join(exedir, "config") {
+			t.Errorf("Expected dir %v but got %v instead", filepath.Join(exedir, "config"), result.Dir)
+		}
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+// slowFS delays opening each file and directory in fsys.
+type slowFS struct {
+	fsys  fs.FS
+	delay time.Duration
+}
+
+func (s slowFS) Open(name string) (fs.File, error) {
+	time.Sleep(s.delay)
+	return s.fsys.Open(name)
+}
+
+func TestParserWithFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"username":    {Data: []byte("fsuser")},
+		"tls/key":     {Data: []byte("secret")},
+		"ca/ca-1.pem": {Data: []byte("one\n")},
+		"ca/ca-2.pem": {Data: []byte("two\n")},
+		"ca/ca-3.d/x": {Data: []byte("ignored\n")},
+	}
+
+	type Config struct {
+		Username string `noenv:"true"`
+		Key      string `noenv:"true" file:"tls/key"`
+		CA       string `noenv:"true" file:"ca-*"`
+		Chain    string `noenv:"true" file:"ca/ca-*"`
+		Port     int    `noenv:"true" default:"8080"`
+	}
+
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	result := Config{}
+	if err := NewParser().WithFS(fsys).Parse(&result); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := Config{"fsuser", "secret", "one\ntwo\n", "one\ntwo\n", 8080}
+	if result != expected {
+		t.Errorf("Expected %+v but got %+v instead", expected, result)
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestTimeout(t *testing.T) {
+	fsys := fstest.MapFS{
+		"username": {Data: []byte("fsuser")},
+	}
+
+	type Config struct {
+		Username string `noenv:"true"`
+	}
+
+	tables := []struct {
+		timeout time.Duration
+		isErr   bool
+	}{
+		{0, false},
+		{10 * time.Second, false},
+		{20 * time.Millisecond, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags([]string{})
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		result := Config{}
+		start := time.Now()
+		err := NewParser().WithFS(slowFS{fsys, 100 * time.Millisecond}).WithOptions(Options{Timeout: table.timeout}).Parse(&result)
+		if table.isErr {
+			if !errors.Is(err, ErrTimeout) {
+				t.Errorf("Expected a timeout error but got %v instead", err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("Expected the parse to give up after the timeout but it took %v", elapsed)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result.Username != "fsuser" {
+			t.Errorf("Expected username fsuser but got %v instead", result.Username)
+		}
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
@@ -0,0 +1,59 @@
+package configparser
+
+import (
+	"fmt"
+	"os"
+)
+
+// terminal prompts the user for the values of missing fields. See
+// Options.PromptForMissing.
+type terminal interface {
+	// isTerminal reports whether there is a user to prompt.
+	isTerminal() bool
+	// readSecret writes prompt and reads a line without echoing it.
+	readSecret(prompt string) (string, error)
+}
+
+// stdinTerminal prompts on standard error and reads from standard input.
+type stdinTerminal struct{}
+
+func (stdinTerminal) isTerminal() bool {
+	return isTerminalFd(os.Stdin.Fd())
+}
+
+func (stdinTerminal) readSecret(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	// The newline typed by the user is not echoed either.
+	defer fmt.Fprintln(os.Stderr)
+	return readNoEcho(os.Stdin.Fd())
+}
+
+// promptForMissing prompts on the terminal for each mandatory param in params
+// which was not set from any of its sources, in the order they are declared.
+// Nothing is prompted for if there is no terminal, and a param which is left
+// empty stays missing.
+func promptForMissing(params []*param, byName map[string]*param, opts Options) error {
+	term := opts.terminal
+	if term == nil {
+		term = stdinTerminal{}
+	}
+	if !term.isTerminal() {
+		return nil
+	}
+	for _, p := range params {
+		if !p.isMissing(byName) || p.structElems || p.lazySecret {
+			continue
+		}
+		val, err := term.readSecret(p.name + ": ")
+		if err != nil {
+			return fmt.Errorf("error reading field %s from the terminal: %w", p.name, err)
+		}
+		if val == "" {
+			continue
+		}
+		if err := p.setParam(val, "terminal", p.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
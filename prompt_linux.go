@@ -0,0 +1,51 @@
+package configparser
+
+import (
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// ioctlTermios gets or sets the terminal attributes of fd, depending on req.
+func ioctlTermios(fd, req uintptr, termios *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(unsafe.Pointer(termios)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// isTerminalFd reports whether fd refers to a terminal.
+func isTerminalFd(fd uintptr) bool {
+	var termios syscall.Termios
+	return ioctlTermios(fd, syscall.TCGETS, &termios) == nil
+}
+
+// readNoEcho reads a line from the terminal fd with echo turned off, and
+// restores the terminal's attributes afterwards. The line is returned
+// without its line ending.
+func readNoEcho(fd uintptr) (string, error) {
+	var old syscall.Termios
+	if err := ioctlTermios(fd, syscall.TCGETS, &old); err != nil {
+		return "", err
+	}
+	noecho := old
+	noecho.Lflag &^= syscall.ECHO
+	noecho.Lflag |= syscall.ICANON | syscall.ISIG
+	noecho.Iflag |= syscall.ICRNL
+	if err := ioctlTermios(fd, syscall.TCSETS, &noecho); err != nil {
+		return "", err
+	}
+	defer ioctlTermios(fd, syscall.TCSETS, &old)
+
+	// Read a byte at a time so that nothing after the line is consumed.
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := syscall.Read(int(fd), buf)
+		if n == 0 || err != nil || buf[0] == '\n' {
+			return strings.TrimSuffix(string(line), "\r"), err
+		}
+		line = append(line, buf[0])
+	}
+}
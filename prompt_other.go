@@ -0,0 +1,16 @@
+//go:build !linux
+
+package configparser
+
+import "errors"
+
+// isTerminalFd reports whether fd refers to a terminal. Terminals are only
+// detected on Linux, so missing fields are never prompted for elsewhere.
+func isTerminalFd(fd uintptr) bool {
+	return false
+}
+
+// readNoEcho is not supported on this platform.
+func readNoEcho(fd uintptr) (string, error) {
+	return "", errors.New("reading from the terminal is not supported on this platform")
+}
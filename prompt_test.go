@@ -0,0 +1,81 @@
+package configparser
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// fakeTerminal answers prompts from a fixed list of lines.
+type fakeTerminal struct {
+	tty     bool
+	lines   []string
+	prompts []string
+}
+
+func (t *fakeTerminal) isTerminal() bool {
+	return t.tty
+}
+
+func (t *fakeTerminal) readSecret(prompt string) (string, error) {
+	t.prompts = append(t.prompts, prompt)
+	if len(t.lines) == 0 {
+		return "", errors.New("EOF")
+	}
+	line := t.lines[0]
+	t.lines = t.lines[1:]
+	return line, nil
+}
+
+func TestPromptForMissing(t *testing.T) {
+	type Config struct {
+		Host     string `default:"localhost"`
+		Password string `mandatory:"true"`
+		Port     int    `mandatory:"true"`
+		Debug    bool
+	}
+
+	tables := []struct {
+		env      map[string]string
+		term     *fakeTerminal
+		expected Config
+		prompts  []string
+		isErr    bool
+	}{
+		{map[string]string{}, &fakeTerminal{tty: true, lines: []string{"s3cret", "8080"}}, Config{"localhost", "s3cret", 8080, false}, []string{"Password: ", "Port: "}, false},
+		{map[string]string{"PORT": "9090"}, &fakeTerminal{tty: true, lines: []string{"s3cret"}}, Config{"localhost", "s3cret", 9090, false}, []string{"Password: "}, false},
+		{map[string]string{}, &fakeTerminal{tty: false, lines: []string{"s3cret", "8080"}}, Config{}, nil, true},
+		{map[string]string{}, &fakeTerminal{tty: true, lines: []string{"", "8080"}}, Config{}, []string{"Password: ", "Port: "}, true},
+		{map[string]string{}, &fakeTerminal{tty: true, lines: []string{"s3cret", "http"}}, Config{}, []string{"Password: ", "Port: "}, true},
+		{map[string]string{}, &fakeTerminal{tty: true}, Config{}, []string{"Password: "}, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		pr := deterministicParser("", table.env, nil, Options{PromptForMissing: true})
+		pr.opts.flagSet.SetOutput(new(bytes.Buffer))
+		pr.opts.terminal = table.term
+
+		result := Config{}
+		err := pr.Parse(&result)
+		if !reflect.DeepEqual(table.term.prompts, table.prompts) {
+			t.Errorf("Expected prompts %q but got %q instead", table.prompts, table.term.prompts)
+		}
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+}
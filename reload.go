@@ -0,0 +1,100 @@
+package configparser
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// FieldChange holds the old and new value of a field which was changed by
+// Reload, in the same format they would be read from a config source.
+type FieldChange struct {
+	Old string
+	New string
+}
+
+// Reload parses the struct pointed to by ptrtostruct again, in the same way
+// as ParseWithDir, and reports the fields whose values changed, keyed by
+// field name. This allows an application to react to new config narrowly,
+// e.g. by only rebuilding its TLS config when a certificate file changed.
+//
+// Files and environment variables are read again, but the command line is
+// not: flag.CommandLine is left alone, and fields keep the values given to
+// them by command line flags, subject to the usual order of precedence. The
+// struct is only changed if parsing succeeds.
+func Reload(ptrtostruct interface{}, dir string) (map[string]FieldChange, error) {
+	return NewParser().WithDir(dir).Reload(ptrtostruct)
+}
+
+// Reload behaves like the package-level Reload, using the parser's
+// configuration.
+func (pr *Parser) Reload(ptrtostruct interface{}) (map[string]FieldChange, error) {
+	structval, err := structValue(ptrtostruct)
+	if err != nil {
+		return nil, err
+	}
+
+	// The struct is parsed into a new value with a flag set of its own, so
+	// that the struct's flags aren't registered with the parser's flag set
+	// again. The flags registered by other code are accepted and ignored.
+	reloaded := reflect.New(structval.Type())
+	fs := pr.opts.commandLine()
+	rp := *pr
+	rp.opts.flagSet = flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	rp.opts.flagSet.SetOutput(io.Discard)
+	fs.VisitAll(func(f *flag.Flag) {
+		switch f.Value.(type) {
+		case *param, *negatedFlag:
+			return
+		}
+		bf, ok := f.Value.(interface{ IsBoolFlag() bool })
+		rp.opts.flagSet.Var(ignoredFlag{ok && bf.IsBoolFlag()}, f.Name, f.Usage)
+	})
+	if err := rp.Parse(reloaded.Interface()); err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]FieldChange)
+	structtype := structval.Type()
+	for i := 0; i < structtype.NumField(); i++ {
+		structfield := structtype.Field(i)
+		// Functions, i.e. lazy secrets, can't be compared, and read their
+		// value each time they are called anyway.
+		if !structfield.IsExported() || structfield.Type.Kind() == reflect.Func {
+			continue
+		}
+		oldfield, newfield := structval.Field(i), reloaded.Elem().Field(i)
+		if p := optionsParam(structfield, oldfield, pr.opts); p != nil {
+			// The values are compared before a secret is redacted.
+			if np := optionsParam(structfield, newfield, pr.opts); p.value() != np.value() {
+				changes[structfield.Name] = FieldChange{p.String(), np.String()}
+			}
+			continue
+		}
+		if !reflect.DeepEqual(oldfield.Interface(), newfield.Interface()) {
+			changes[structfield.Name] = FieldChange{fmt.Sprint(oldfield.Interface()), fmt.Sprint(newfield.Interface())}
+		}
+	}
+	structval.Set(reloaded.Elem())
+	return changes, nil
+}
+
+// ignoredFlag stands in for a command line flag registered by other code when
+// the command line is parsed again by Reload, so that the flag and its value
+// are accepted without affecting anything.
+type ignoredFlag struct {
+	isBool bool
+}
+
+func (f ignoredFlag) String() string {
+	return ""
+}
+
+func (f ignoredFlag) Set(string) error {
+	return nil
+}
+
+func (f ignoredFlag) IsBoolFlag() bool {
+	return f.isBool
+}
@@ -0,0 +1,110 @@
+package configparser
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	dir, err := createFilesInTempDir(map[string]configFile{
+		"cert": {contents: "old cert"},
+		"port": {contents: "8080"},
+	})
+	if err != nil {
+		t.Errorf("Could not create files in temp dir: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	type Config struct {
+		Cert     string `noenv:"true"`
+		Port     int    `noenv:"true"`
+		Username string `noenv:"true" default:"nobody"`
+	}
+
+	setFlags([]string{"-verbose", "-username", "admin"})
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	flag.Bool("verbose", false, "verbose output")
+
+	result := Config{}
+	if err := ParseWithDir(&result, dir); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "cert"), []byte("new cert"), 0600); err != nil {
+		t.Fatalf("Could not write file: %v", err)
+	}
+	changes, err := Reload(&result, dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectedChanges := map[string]FieldChange{"Cert": {"old cert", "new cert"}}
+	if !reflect.DeepEqual(changes, expectedChanges) {
+		t.Errorf("Expected changes %v but got %v instead", expectedChanges, changes)
+	}
+	expected := Config{"new cert", 8080, "admin"}
+	if result != expected {
+		t.Errorf("Expected %+v but got %+v instead", expected, result)
+	}
+
+	changes, err = Reload(&result, dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("Expected no changes but got %v instead", changes)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "port"), []byte("http"), 0600); err != nil {
+		t.Fatalf("Could not write file: %v", err)
+	}
+	if _, err := Reload(&result, dir); err == nil {
+		t.Error("Expected an error for an invalid port but did not get it")
+	}
+	if result != expected {
+		t.Errorf("Expected the struct to be left alone as %+v but got %+v instead", expected, result)
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestReloadSecret(t *testing.T) {
+	dir, err := createFilesInTempDir(map[string]configFile{
+		"password": {contents: "hunter2"},
+	})
+	if err != nil {
+		t.Fatalf("Could not create files in temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	type Config struct {
+		Password string `noenv:"true" secret:"true"`
+	}
+
+	pr := deterministicParser(dir, nil, nil, Options{})
+	result := Config{}
+	if err := pr.Parse(&result); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "password"), []byte("hunter3"), 0600); err != nil {
+		t.Fatalf("Could not write file: %v", err)
+	}
+	changes, err := pr.Reload(&result)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectedChanges := map[string]FieldChange{"Password": {"****", "****"}}
+	if !reflect.DeepEqual(changes, expectedChanges) {
+		t.Errorf("Expected changes %v but got %v instead", expectedChanges, changes)
+	}
+	if result.Password != "hunter3" {
+		t.Errorf("Expected hunter3 but got %s instead", result.Password)
+	}
+}
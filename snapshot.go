@@ -0,0 +1,88 @@
+package configparser
+
+import "reflect"
+
+// Snapshot returns a deep copy of the struct pointed to by ptrtostruct, as a
+// pointer of the same type, e.g. a *Config for a *Config argument. Pointers,
+// slices, maps and arrays are copied rather than shared, so the snapshot is
+// unaffected by later changes to the original, such as a reload. This allows
+// an old and new config to be compared, or the old one to be restored.
+//
+// Unexported fields, functions, channels and the values held in interfaces
+// are copied as they are, as is *regexp.Regexp, which is safe to share. It
+// returns nil if ptrtostruct is not a pointer to a struct.
+func Snapshot(ptrtostruct interface{}) interface{} {
+	structval, err := structValue(ptrtostruct)
+	if err != nil {
+		return nil
+	}
+	snapshot := reflect.New(structval.Type())
+	deepCopy(snapshot.Elem(), structval, make(map[uintptr]reflect.Value))
+	return snapshot.Interface()
+}
+
+// deepCopy sets dst, which must be settable, to a deep copy of src. copied
+// maps the pointers which have already been copied to their copies, so that
+// shared and cyclic pointers are preserved.
+func deepCopy(dst, src reflect.Value, copied map[uintptr]reflect.Value) {
+	switch src.Kind() {
+	case reflect.Ptr:
+		if src.IsNil() || src.Type() == regexpType {
+			dst.Set(src)
+			return
+		}
+		if c, ok := copied[src.Pointer()]; ok && c.Type() == src.Type() {
+			dst.Set(c)
+			return
+		}
+		c := reflect.New(src.Type().Elem())
+		copied[src.Pointer()] = c
+		deepCopy(c.Elem(), src.Elem(), copied)
+		dst.Set(c)
+
+	case reflect.Slice:
+		if src.IsNil() {
+			dst.Set(src)
+			return
+		}
+		c := reflect.MakeSlice(src.Type(), src.Len(), src.Len())
+		for i := 0; i < src.Len(); i++ {
+			deepCopy(c.Index(i), src.Index(i), copied)
+		}
+		dst.Set(c)
+
+	case reflect.Array:
+		for i := 0; i < src.Len(); i++ {
+			deepCopy(dst.Index(i), src.Index(i), copied)
+		}
+
+	case reflect.Map:
+		if src.IsNil() {
+			dst.Set(src)
+			return
+		}
+		c := reflect.MakeMapWithSize(src.Type(), src.Len())
+		iter := src.MapRange()
+		for iter.Next() {
+			k := reflect.New(src.Type().Key()).Elem()
+			deepCopy(k, iter.Key(), copied)
+			v := reflect.New(src.Type().Elem()).Elem()
+			deepCopy(v, iter.Value(), copied)
+			c.SetMapIndex(k, v)
+		}
+		dst.Set(c)
+
+	case reflect.Struct:
+		// Copy the whole struct first so that unexported fields, which
+		// can't be set individually, are carried over.
+		dst.Set(src)
+		for i := 0; i < src.NumField(); i++ {
+			if dst.Field(i).CanSet() {
+				deepCopy(dst.Field(i), src.Field(i), copied)
+			}
+		}
+
+	default:
+		dst.Set(src)
+	}
+}
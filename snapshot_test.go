@@ -0,0 +1,68 @@
+package configparser
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	type Upstream struct {
+		Host string
+		Tags []string
+	}
+	type Config struct {
+		Hostname  string
+		Ports     []int
+		Limits    map[string]int
+		Upstream  *Upstream
+		Upstreams []Upstream
+		Key       [4]byte
+		Timeout   time.Duration
+		Include   *regexp.Regexp
+		private   []string
+	}
+
+	original := &Config{
+		Hostname:  "localhost",
+		Ports:     []int{80, 443},
+		Limits:    map[string]int{"a": 1},
+		Upstream:  &Upstream{"up", []string{"x"}},
+		Upstreams: []Upstream{{"one", []string{"y"}}},
+		Key:       [4]byte{1, 2, 3, 4},
+		Timeout:   time.Second,
+		Include:   regexp.MustCompile("^a"),
+		private:   []string{"p"},
+	}
+
+	snapshot, ok := Snapshot(original).(*Config)
+	if !ok {
+		t.Fatalf("Expected a *Config but got %T instead", Snapshot(original))
+	}
+	if !reflect.DeepEqual(snapshot, original) {
+		t.Fatalf("Expected %+v but got %+v instead", original, snapshot)
+	}
+	expected := *snapshot
+	expected.Upstream = &Upstream{"up", []string{"x"}}
+	expected.Upstreams = []Upstream{{"one", []string{"y"}}}
+
+	original.Hostname = "changed"
+	original.Ports[0] = 8080
+	original.Limits["a"] = 2
+	original.Upstream.Host = "changed"
+	original.Upstream.Tags[0] = "changed"
+	original.Upstreams[0].Tags[0] = "changed"
+	original.Key[0] = 9
+
+	if snapshot.Hostname != "localhost" || snapshot.Ports[0] != 80 || snapshot.Limits["a"] != 1 || snapshot.Key[0] != 1 {
+		t.Errorf("Expected the snapshot to be unaffected but got %+v", snapshot)
+	}
+	if !reflect.DeepEqual(snapshot.Upstream, expected.Upstream) || !reflect.DeepEqual(snapshot.Upstreams, expected.Upstreams) {
+		t.Errorf("Expected the snapshot's upstreams to be unaffected but got %+v and %+v", snapshot.Upstream, snapshot.Upstreams)
+	}
+
+	if s := Snapshot(Config{}); s != nil {
+		t.Errorf("Expected nil for a struct passed by value but got %v", s)
+	}
+}
@@ -0,0 +1,243 @@
+package configparser
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// Sources which can be listed in the sources tag.
+const (
+	sourceFile     = "file"
+	sourceRelFile  = "relfile"
+	sourceFilePath = "filepath"
+	sourceEnv      = "env"
+	sourceKeyring  = "keyring"
+	sourceFlag     = "flag"
+	sourceDocument = "document"
+	sourceDefault  = "default"
+)
+
+// lookupSources are the sources which are looked up after the command line
+// flags have been parsed, in order of precedence, for fields without a sources
+// tag. The default, document and command line flag are applied as they are
+// encountered, and are overridden by any of these.
+var lookupSources = []string{sourceFile, sourceRelFile, sourceFilePath, sourceEnv, sourceKeyring}
+
+// parseSources splits the value of a sources tag into its sources, returning
+// an error if any of them is unknown or repeated.
+func parseSources(tag string) ([]string, error) {
+	sources := strings.Split(tag, ",")
+	seen := make(map[string]bool)
+	for i := range sources {
+		sources[i] = strings.TrimSpace(sources[i])
+		switch sources[i] {
+		case sourceFile, sourceRelFile, sourceFilePath, sourceEnv, sourceKeyring, sourceFlag, sourceDocument, sourceDefault:
+		default:
+			return nil, fmt.Errorf("unknown source %q", sources[i])
+		}
+		if seen[sources[i]] {
+			return nil, fmt.Errorf("source %q is listed more than once", sources[i])
+		}
+		seen[sources[i]] = true
+	}
+	return sources, nil
+}
+
+// noSourceReason explains why a mandatory field with the given sources tag,
+// or no sources tag if sources is nil, has no source it can be set from.
+func noSourceReason(sources []string) string {
+	if sources == nil {
+		return "it has both noenv and noflag tags, no relfile or filepath tag, and there is no config directory"
+	}
+	listed := sources[len(sources)-1]
+	if len(sources) > 1 {
+		listed = strings.Join(sources[:len(sources)-1], ", ") + " and " + listed
+	}
+	reason := "its sources tag only lists " + listed
+	var missing []string
+	for _, source := range sources {
+		switch source {
+		case sourceFile:
+			missing = append(missing, "there is no config directory")
+		case sourceRelFile, sourceFilePath, sourceKeyring:
+			missing = append(missing, "it has no "+source+" tag")
+		case sourceDocument:
+			missing = append(missing, "it is not parsed from a document")
+		}
+	}
+	if len(missing) > 0 {
+		reason += ", and " + strings.Join(missing, ", ")
+	}
+	return reason
+}
+
+// hasSource returns true if sources includes source.
+func hasSource(sources []string, source string) bool {
+	for _, s := range sources {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveSources sets p from the first of sources which has a value for it.
+// found is false if none of them do.
+func resolveSources(p *param, sources []string, configFiles map[string]string, dir string, opts Options) (found bool, err error) {
+	for _, source := range sources {
+		found, err := setParamFromSource(p, source, configFiles, dir, opts)
+		if err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+// resolveSourceChain clears p, including the sources it has been assigned
+// from so far, and then sets it from the first source in its sources tag
+// which has a value for it.
+func resolveSourceChain(p *param, configFiles map[string]string, dir string, opts Options) error {
+	if p.fieldPointer != nil {
+		reflect.NewAt(reflect.PtrTo(p.fieldType), p.fieldPointer).Elem().Set(reflect.Zero(reflect.PtrTo(p.fieldType)))
+		p.paramPointer = nil
+		p.allocated = false
+	} else {
+		field := reflect.NewAt(p.fieldType, p.paramPointer).Elem()
+		field.Set(reflect.Zero(p.fieldType))
+	}
+	p.isSet = false
+	p.source = ""
+	p.sourceKey = ""
+	p.assignedFrom = nil
+	_, err := resolveSources(p, p.sources, configFiles, dir, opts)
+	return err
+}
+
+// setParamFromSource sets p from source, returning false if the source has no
+// value for the field.
+func setParamFromSource(p *param, source string, configFiles map[string]string, dir string, opts Options) (found bool, err error) {
+	switch source {
+	case sourceFile:
+		if p.filename == "" {
+			return false, nil
+		}
+		return setParamFromConfigFiles(p, configFiles, dir, opts)
+
+	case sourceRelFile:
+		if p.relFile == "" {
+			return false, nil
+		}
+		wd, err := os.Getwd()
+		if err != nil {
+			return false, err
+		}
+		return setParamFromFile(p, nil, filepath.Join(wd, p.relFile), p.relFile, opts)
+
+	case sourceFilePath:
+		if p.filePath == "" {
+			return false, nil
+		}
+		return setParamFromFilePath(p, opts)
+
+	case sourceEnv:
+		if p.structElems {
+			return p.setStructElems(opts)
+		}
+		if p.envIndexed != "" {
+			if elems := indexedEnv(p.envIndexed, opts); len(elems) > 0 {
+				return true, p.setParamElems(elems, "environment variables", p.envIndexed+"_*")
+			}
+		}
+		if p.envKey == "" {
+			return false, nil
+		}
+		key, envval, ok := p.lookupEnv(opts)
+		if !ok {
+			if opts.FileEnvVars {
+				return setParamFromFileEnv(p, opts)
+			}
+			return false, nil
+		}
+		return true, p.setParam(envval, "environment variable", key)
+
+	case sourceKeyring:
+		if p.keyringService == "" || opts.KeyringGetter == nil {
+			return false, nil
+		}
+		val, err := opts.KeyringGetter(p.keyringService, p.keyringAccount)
+		if errors.Is(err, ErrKeyringNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("error reading field %s from keyring service %s account %s: %w", p.name, p.keyringService, p.keyringAccount, err)
+		}
+		return true, p.setParam(val, "keyring entry", p.keyringService+"/"+p.keyringAccount)
+
+	case sourceFlag:
+		if !p.flagSeen {
+			return false, nil
+		}
+		return true, p.setParam(p.flagValue, "command line flag", p.flagKey)
+
+	case sourceDocument:
+		if !p.hasDocument {
+			return false, nil
+		}
+		return true, p.setParam(p.documentValue, "document key", p.documentKey)
+
+	case sourceDefault:
+		if !p.hasDefault {
+			return false, nil
+		}
+		return true, p.setParam(p.defaultValue, "default value", p.name)
+	}
+	return false, nil
+}
+
+// fileEnvSuffix is appended to a field's environment variable to get the name
+// of the variable which holds the path of a file containing the field's
+// value. See Options.FileEnvVars.
+const fileEnvSuffix = "_FILE"
+
+// setParamFromFileEnv sets p from the file named in its environment variable
+// with fileEnvSuffix appended, e.g. PASSWORD_FILE. found is false if that
+// variable is not set. The file must exist if the variable is set.
+func setParamFromFileEnv(p *param, opts Options) (found bool, err error) {
+	key := p.envKey + fileEnvSuffix
+	path, ok := opts.lookupEnv(key)
+	if !ok {
+		return false, nil
+	}
+	if _, err := os.Stat(path); err != nil {
+		return false, fmt.Errorf("file %s in environment variable %s for field %s could not be read: %v", path, key, p.name, err)
+	}
+	return setParamFromFile(p, nil, path, key, opts)
+}
+
+// checkFileEnvConflict returns an error if p was set from a file other than
+// the one named in its _FILE environment variable, but that variable also
+// names a file which exists, as it is then unclear which file was meant. If
+// opts.FileEnvConflictAsWarning is set, a warning is logged instead.
+func checkFileEnvConflict(p *param, opts Options) error {
+	key := p.envKey + fileEnvSuffix
+	if p.envKey == "" || p.source != sourceFile || p.sourceKey == key {
+		return nil
+	}
+	path, ok := opts.lookupEnv(key)
+	if !ok {
+		return nil
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	msg := fmt.Sprintf("field %s is set from both file %s and file %s in environment variable %s", p.name, p.sourceKey, path, key)
+	if opts.FileEnvConflictAsWarning {
+		opts.logf("%s - using file %s", msg, p.sourceKey)
+		return nil
+	}
+	return errors.New(msg)
+}
@@ -0,0 +1,256 @@
+package configparser
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSourcesChain(t *testing.T) {
+	type Config struct {
+		Host string `sources:"flag,env,default" default:"localhost"`
+		Port int    `sources:"file,default" default:"8080"`
+	}
+
+	dir, err := createFilesInTempDir(map[string]configFile{"port": {contents: "9090"}})
+	if err != nil {
+		t.Fatalf("Could not create files in temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tables := []struct {
+		flags    []string
+		env      map[string]string
+		dir      string
+		expected Config
+	}{
+		{[]string{}, nil, "", Config{"localhost", 8080}},
+		{[]string{}, map[string]string{"HOST": "env.example.com"}, "", Config{"env.example.com", 8080}},
+		// The flag comes first in the chain, so it beats the environment
+		// variable.
+		{[]string{"-host", "flag.example.com"}, map[string]string{"HOST": "env.example.com"}, "", Config{"flag.example.com", 8080}},
+		// Port has no env or flag source.
+		{[]string{}, map[string]string{"PORT": "7070"}, dir, Config{"localhost", 9090}},
+		{[]string{}, map[string]string{"PORT": "7070"}, "", Config{"localhost", 8080}},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+		for k, v := range table.env {
+			os.Setenv(k, v)
+		}
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		result := Config{}
+		err := ParseWithDir(&result, table.dir)
+		for k := range table.env {
+			os.Unsetenv(k)
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+
+	// Port has no flag.
+	setFlags([]string{"-port", "1"})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	flag.CommandLine.SetOutput(new(strings.Builder))
+	if err := Parse(&Config{}); err == nil {
+		t.Error("Expected an error for a flag which isn't in the sources tag but did not get it")
+	}
+
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	invalid := struct {
+		Host string `sources:"env,vault"`
+	}{}
+	if err := Parse(&invalid); err == nil {
+		t.Error("Expected an error for an unknown source but did not get it")
+	}
+
+	// A source can't be both listed and disabled.
+	for _, contradictory := range []interface{}{
+		&struct {
+			Host string `sources:"env" noenv:"true"`
+		}{},
+		&struct {
+			Host string `sources:"flag,default" noflag:"true"`
+		}{},
+	} {
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		if err := Parse(contradictory); err == nil {
+			t.Errorf("Expected an error for %T but did not get it", contradictory)
+		}
+	}
+
+	// The error for a mandatory field without a source names the sources
+	// it does have.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	err = Parse(&struct {
+		S string `sources:"default,file" mandatory:"true"`
+	}{})
+	if err == nil || strings.Contains(err.Error(), "noenv") || !strings.Contains(err.Error(), "only lists default and file, and there is no config directory") {
+		t.Errorf("Expected an error naming the field's sources but got: %v", err)
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestFileEnvVars(t *testing.T) {
+	type Config struct {
+		DBPassword string `env:"DB_PASSWORD"`
+		DBUser     string `env:"DB_USER" default:"admin"`
+	}
+
+	dir, err := createFilesInTempDir(map[string]configFile{"dbpassword": {contents: "fromdir"}})
+	if err != nil {
+		t.Fatalf("Could not create files in temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	secrets, err := createFilesInTempDir(map[string]configFile{"password": {contents: "fromsecret"}, "user": {contents: "fromsecret"}})
+	if err != nil {
+		t.Fatalf("Could not create files in temp dir: %v", err)
+	}
+	defer os.RemoveAll(secrets)
+
+	tables := []struct {
+		env      map[string]string
+		dir      string
+		opts     Options
+		expected Config
+		warning  string
+		isErr    bool
+	}{
+		{map[string]string{"DB_PASSWORD_FILE": filepath.Join(secrets, "password")}, "", Options{}, Config{"", "admin"}, "", false},
+		{map[string]string{"DB_PASSWORD_FILE": filepath.Join(secrets, "password"), "DB_USER_FILE": filepath.Join(secrets, "user")}, "", Options{FileEnvVars: true}, Config{"fromsecret", "fromsecret"}, "", false},
+		// The environment variable itself takes precedence.
+		{map[string]string{"DB_PASSWORD": "fromenv", "DB_PASSWORD_FILE": filepath.Join(secrets, "password")}, "", Options{FileEnvVars: true}, Config{"fromenv", "admin"}, "", false},
+		{map[string]string{"DB_PASSWORD_FILE": filepath.Join(secrets, "missing")}, "", Options{FileEnvVars: true}, Config{}, "", true},
+		// Both the config directory and DB_PASSWORD_FILE have a file.
+		{map[string]string{"DB_PASSWORD_FILE": filepath.Join(secrets, "password")}, dir, Options{FileEnvVars: true}, Config{}, "", true},
+		{map[string]string{"DB_PASSWORD_FILE": filepath.Join(secrets, "password")}, dir, Options{FileEnvVars: true, FileEnvConflictAsWarning: true}, Config{"fromdir", "admin"}, "field DBPassword is set from both file dbpassword and file", false},
+		{map[string]string{}, dir, Options{FileEnvVars: true}, Config{"fromdir", "admin"}, "", false},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags([]string{})
+		for k, v := range table.env {
+			os.Setenv(k, v)
+		}
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		var buf strings.Builder
+		table.opts.Logger = log.New(&buf, "", 0)
+		result := Config{}
+		err := ParseWithOptions(&result, table.dir, table.opts)
+		for k := range table.env {
+			os.Unsetenv(k)
+		}
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+		if !strings.Contains(buf.String(), table.warning) || (table.warning == "" && buf.Len() > 0) {
+			t.Errorf("Expected the warning %q but got %q instead", table.warning, buf.String())
+		}
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestKeyring(t *testing.T) {
+	type Config struct {
+		Token    string `keyring:"myapp/token" default:"none"`
+		Password string `keyring:"myapp/password" mandatory:"true" noflag:"true"`
+		Broken   string `keyring:"myapp/broken" sources:"flag"`
+	}
+
+	keyring := map[string]string{"myapp/token": "from keyring", "myapp/password": "secret", "myapp/broken": "unused"}
+	getter := func(service, account string) (string, error) {
+		if val, ok := keyring[service+"/"+account]; ok {
+			return val, nil
+		}
+		return "", fmt.Errorf("no entry for %s/%s: %w", service, account, ErrKeyringNotFound)
+	}
+
+	tables := []struct {
+		env      map[string]string
+		args     []string
+		getter   func(service, account string) (string, error)
+		expected Config
+		isErr    bool
+	}{
+		{map[string]string{}, []string{}, getter, Config{"from keyring", "secret", ""}, false},
+		{map[string]string{"TOKEN": "from env"}, []string{}, getter, Config{"from env", "secret", ""}, false},
+		{map[string]string{}, []string{"-token", "from flag"}, getter, Config{"from keyring", "secret", ""}, false},
+		{map[string]string{"PASSWORD": "env secret"}, []string{}, func(service, account string) (string, error) {
+			return "", ErrKeyringNotFound
+		}, Config{"none", "env secret", ""}, false},
+		{map[string]string{}, []string{}, func(service, account string) (string, error) {
+			return "", ErrKeyringNotFound
+		}, Config{}, true},
+		{map[string]string{"PASSWORD": "env secret"}, []string{}, func(service, account string) (string, error) {
+			return "", errors.New("keyring is locked")
+		}, Config{}, true},
+		{map[string]string{"PASSWORD": "env secret"}, []string{"-token", "from flag"}, nil, Config{"from flag", "env secret", ""}, false},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		pr := deterministicParser("", table.env, table.args, Options{KeyringGetter: table.getter})
+		pr.opts.flagSet.SetOutput(new(strings.Builder))
+
+		result := Config{}
+		err := pr.Parse(&result)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result != table.expected {
+			t.Errorf("Expected %+v but got %+v instead", table.expected, result)
+		}
+	}
+
+	invalid := struct {
+		Token string `keyring:"token"`
+	}{}
+	if err := ParseDeterministic(&invalid, "", nil, nil); err == nil {
+		t.Error("Expected an error for a keyring tag without an account but did not get it")
+	}
+}
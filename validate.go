@@ -0,0 +1,369 @@
+package configparser
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Group policies which can be specified with the grouppolicy tag.
+const (
+	groupPolicyAllOrNone = "allornone"
+)
+
+// Options which can be specified in the path tag.
+const (
+	pathMustExist = "mustexist"
+	pathReadable  = "readable"
+)
+
+// parseConstraints reads the validation tags in tag into p. It returns an
+// error if a tag is malformed or doesn't apply to the field's type.
+func (p *param) parseConstraints(tag reflect.StructTag) error {
+	if m, ok := tag.Lookup("multipleof"); ok {
+		if p.fieldKind != reflect.Int || p.fieldType == durationType {
+			return fmt.Errorf("field %s has a multipleof tag but is not an int", p.name)
+		}
+		i, err := strconv.Atoi(m)
+		if err != nil || i <= 0 {
+			return fmt.Errorf("field %s has a multipleof tag which is not a positive integer: %v", p.name, m)
+		}
+		p.multipleOf = i
+	}
+	if path, ok := tag.Lookup("path"); ok {
+		if p.fieldKind != reflect.String || p.unmarshalJSON || p.converter != nil {
+			return fmt.Errorf("field %s has a path tag but is not a string", p.name)
+		}
+		for _, opt := range strings.Split(path, ",") {
+			switch strings.TrimSpace(opt) {
+			case pathMustExist:
+				p.pathMustExist = true
+			case pathReadable:
+				p.pathReadable = true
+			default:
+				return fmt.Errorf("field %s has an unknown path option: %v", p.name, opt)
+			}
+		}
+		if p.pathReadable && !p.pathMustExist {
+			return fmt.Errorf("field %s has a readable path option without mustexist", p.name)
+		}
+	}
+	for _, bound := range []struct {
+		tag string
+		s   *string
+		val *float64
+	}{{"min", &p.min, &p.minVal}, {"max", &p.max, &p.maxVal}} {
+		b, ok := tag.Lookup(bound.tag)
+		if !ok {
+			continue
+		}
+		if !p.isOrdered() {
+			return fmt.Errorf("field %s has a %s tag but is not a number or a duration", p.name, bound.tag)
+		}
+		val, err := p.parseBound(b)
+		if err != nil {
+			return fmt.Errorf("field %s has an invalid %s tag: %v", p.name, bound.tag, b)
+		}
+		*bound.s = b
+		*bound.val = val
+	}
+	if p.min != "" && p.max != "" && p.minVal > p.maxVal {
+		return fmt.Errorf("field %s has a min tag which is greater than its max tag", p.name)
+	}
+	if oneof, ok := tag.Lookup("oneof"); ok {
+		if p.fieldKind != reflect.String && p.fieldKind != reflect.Int || p.unmarshalJSON || p.converter != nil {
+			return fmt.Errorf("field %s has a oneof tag but is not a string or an int", p.name)
+		}
+		for _, v := range strings.Split(oneof, ",") {
+			p.oneOf = append(p.oneOf, strings.TrimSpace(v))
+		}
+	}
+	if pattern, ok := tag.Lookup("pattern"); ok {
+		if p.fieldKind != reflect.String || p.unmarshalJSON || p.converter != nil {
+			return fmt.Errorf("field %s has a pattern tag but is not a string", p.name)
+		}
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return fmt.Errorf("field %s has an invalid pattern tag: %v", p.name, err)
+		}
+		p.pattern = re
+	}
+	for _, bound := range []struct {
+		tag string
+		val *int
+		has *bool
+	}{{"minitems", &p.minItems, &p.hasMinItems}, {"maxitems", &p.maxItems, &p.hasMaxItems}} {
+		b, ok := tag.Lookup(bound.tag)
+		if !ok {
+			continue
+		}
+		if p.fieldKind != reflect.Slice || p.unmarshalJSON || p.converter != nil {
+			return fmt.Errorf("field %s has a %s tag but is not a slice", p.name, bound.tag)
+		}
+		i, err := strconv.Atoi(b)
+		if err != nil || i < 0 {
+			return fmt.Errorf("field %s has a %s tag which is not a non-negative integer: %v", p.name, bound.tag, b)
+		}
+		*bound.val = i
+		*bound.has = true
+	}
+	if p.hasMinItems && p.hasMaxItems && p.minItems > p.maxItems {
+		return fmt.Errorf("field %s has a minitems tag which is greater than its maxitems tag", p.name)
+	}
+	if checksum, ok := tag.Lookup("checksum"); ok {
+		if p.fieldKind != reflect.String || p.unmarshalJSON || p.converter != nil {
+			return fmt.Errorf("field %s has a checksum tag but is not a string", p.name)
+		}
+		i := strings.IndexByte(checksum, ':')
+		if i < 0 || checksumAlgorithms[checksum[:i]] == nil || checksum[i+1:] == "" {
+			return fmt.Errorf("field %s has a checksum tag which is not of the form algorithm:field: %v", p.name, checksum)
+		}
+		p.checksumAlgo = checksum[:i]
+		p.checksumField = checksum[i+1:]
+	}
+	if equalto, ok := tag.Lookup("equalto"); ok {
+		k := p.fieldKind
+		if k != reflect.String && k != reflect.Int && k != reflect.Bool && !isSizedIntKind(k) && !isUintKind(k) || p.unmarshalJSON || p.converter != nil {
+			return fmt.Errorf("field %s has an equalto tag but is not a string, an integer or a bool", p.name)
+		}
+		p.equalTo = equalto
+	}
+	p.group = tag.Get("group")
+	p.groupPolicy = tag.Get("grouppolicy")
+	if p.groupPolicy != "" && p.groupPolicy != groupPolicyAllOrNone {
+		return fmt.Errorf("field %s has an unknown grouppolicy: %v", p.name, p.groupPolicy)
+	}
+	if p.groupPolicy != "" && p.group == "" {
+		return fmt.Errorf("field %s has a grouppolicy tag but no group tag", p.name)
+	}
+	return nil
+}
+
+// isOrdered returns true if the field can have min and max constraints.
+func (p param) isOrdered() bool {
+	if p.unmarshalJSON || p.converter != nil {
+		return false
+	}
+	k := p.fieldKind
+	return p.fieldType == durationType || k == reflect.Int || k == reflect.Float64 || k == reflect.Float32 || isSizedIntKind(k) || isUintKind(k)
+}
+
+// parseBound parses the value of a min or max tag, which is a duration for
+// time.Duration fields and a number otherwise.
+func (p param) parseBound(b string) (float64, error) {
+	if p.fieldType == durationType {
+		d, err := time.ParseDuration(b)
+		return float64(d), err
+	}
+	return strconv.ParseFloat(b, 64)
+}
+
+// orderedValue returns the value of a field for which isOrdered is true.
+func (p param) orderedValue() float64 {
+	switch {
+	case p.fieldType == durationType:
+		return float64(*(*time.Duration)(p.paramPointer))
+	case p.fieldKind == reflect.Float64:
+		return *(*float64)(p.paramPointer)
+	case p.fieldKind == reflect.Float32:
+		return float64(*(*float32)(p.paramPointer))
+	case isSizedIntKind(p.fieldKind):
+		return float64(reflect.NewAt(p.fieldType, p.paramPointer).Elem().Int())
+	case isUintKind(p.fieldKind):
+		return float64(reflect.NewAt(p.fieldType, p.paramPointer).Elem().Uint())
+	}
+	return float64(*(*int)(p.paramPointer))
+}
+
+// validate checks the field's value against the constraints read by
+// parseConstraints. The min, max, oneof and pattern constraints only apply to
+// fields which have been set.
+func (p *param) validate() error {
+	if p.paramPointer == nil {
+		// a pointer field which wasn't set
+		return nil
+	}
+	if p.isSet {
+		if err := p.validateValue(); err != nil {
+			return err
+		}
+	}
+	if p.hasMinItems || p.hasMaxItems {
+		if err := p.validateItems(); err != nil {
+			return err
+		}
+	}
+	if p.multipleOf != 0 {
+		i := *(*int)(p.paramPointer)
+		if i%p.multipleOf != 0 {
+			return fmt.Errorf("field %s must be a multiple of %d - instead it is: %d", p.name, p.multipleOf, i)
+		}
+	}
+	if p.pathMustExist {
+		if path := *(*string)(p.paramPointer); path != "" {
+			if err := checkPath(path, p.pathReadable); err != nil {
+				return fmt.Errorf("field %s must be an existing path: %v", p.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateValue checks the field's value against the min, max, oneof and
+// pattern constraints.
+func (p *param) validateValue() error {
+	if p.min != "" && p.orderedValue() < p.minVal {
+		return fmt.Errorf("field %s must be at least %s - instead it is: %s", p.name, p.min, p.String())
+	}
+	if p.max != "" && p.orderedValue() > p.maxVal {
+		return fmt.Errorf("field %s must be at most %s - instead it is: %s", p.name, p.max, p.String())
+	}
+	if p.oneOf != nil {
+		val := p.value()
+		found := false
+		for _, v := range p.oneOf {
+			if v == val {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("field %s must be one of %s - instead it is: %s", p.name, strings.Join(p.oneOf, ", "), p.String())
+		}
+	}
+	if p.pattern != nil {
+		if val := p.value(); !p.pattern.MatchString(val) {
+			return fmt.Errorf("field %s must match the pattern %s - instead it is: %s", p.name, p.pattern, p.String())
+		}
+	}
+	return nil
+}
+
+// validateItems checks the number of elements in a slice field against the
+// minitems and maxitems constraints. An empty slice satisfies minitems unless
+// the field is mandatory.
+func (p *param) validateItems() error {
+	n := reflect.NewAt(p.fieldType, p.paramPointer).Elem().Len()
+	if p.hasMinItems && n < p.minItems && (n > 0 || p.mandatory) {
+		return fmt.Errorf("field %s must have at least %d items - instead it has %d", p.name, p.minItems, n)
+	}
+	if p.hasMaxItems && n > p.maxItems {
+		return fmt.Errorf("field %s must have at most %d items - instead it has %d", p.name, p.maxItems, n)
+	}
+	return nil
+}
+
+// checksumAlgorithms are the hash functions which can be used in the checksum
+// tag.
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// validateChecksum checks the field's value against the hex checksum held in
+// the field sum.
+func (p *param) validateChecksum(sum *param) error {
+	val, expected := p.value(), strings.TrimSpace(sum.value())
+	if val == "" && expected == "" {
+		return nil
+	}
+	h := checksumAlgorithms[p.checksumAlgo]()
+	h.Write([]byte(val))
+	if !strings.EqualFold(hex.EncodeToString(h.Sum(nil)), expected) {
+		// The actual checksum is left out, as the value may be a secret.
+		return fmt.Errorf("field %s does not match the %s checksum in field %s", p.name, p.checksumAlgo, sum.name)
+	}
+	return nil
+}
+
+// validateEqualTo checks that the field's value is equal to other's.
+func (p *param) validateEqualTo(other *param) error {
+	if p.paramPointer == nil || other.paramPointer == nil {
+		if p.paramPointer != other.paramPointer {
+			return fmt.Errorf("field %s must be equal to field %s", p.name, other.name)
+		}
+		return nil
+	}
+	val := reflect.NewAt(p.fieldType, p.paramPointer).Elem().Interface()
+	if val != reflect.NewAt(other.fieldType, other.paramPointer).Elem().Interface() {
+		// The values are left out, as they may be secrets.
+		return fmt.Errorf("field %s must be equal to field %s", p.name, other.name)
+	}
+	return nil
+}
+
+// checkPath returns an error if path does not exist or, if readable is true,
+// cannot be opened for reading.
+func checkPath(path string, readable bool) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	if !readable {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// validateGroups checks that the members of each group comply with the
+// group's policy. Groups are checked in the order they first appear in
+// params.
+func validateGroups(params []*param) error {
+	var names []string
+	members := make(map[string][]*param)
+	for _, p := range params {
+		if p.group == "" {
+			continue
+		}
+		if _, ok := members[p.group]; !ok {
+			names = append(names, p.group)
+		}
+		members[p.group] = append(members[p.group], p)
+	}
+
+	for _, name := range names {
+		policy := ""
+		for _, p := range members[name] {
+			if p.groupPolicy == "" {
+				continue
+			}
+			if policy != "" && policy != p.groupPolicy {
+				return fmt.Errorf("group %s has conflicting policies: %s and %s", name, policy, p.groupPolicy)
+			}
+			policy = p.groupPolicy
+		}
+
+		var all, set []string
+		for _, p := range members[name] {
+			all = append(all, p.name)
+			if p.isSet {
+				set = append(set, p.name)
+			}
+		}
+
+		switch policy {
+		case groupPolicyAllOrNone:
+			if len(set) > 0 && len(set) < len(all) {
+				return fmt.Errorf("fields %s in group %s must either all be set or all be unset - only %s set", strings.Join(all, ", "), name, strings.Join(set, ", "))
+			}
+		default:
+			return fmt.Errorf("group %s has no grouppolicy", name)
+		}
+	}
+	return nil
+}
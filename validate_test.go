@@ -0,0 +1,505 @@
+package configparser
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMultipleOf(t *testing.T) {
+	type Config struct {
+		BufferSize int `multipleof:"4096" default:"8192"`
+	}
+
+	tables := []struct {
+		flags    []string
+		expected int
+		isErr    bool
+	}{
+		{[]string{}, 8192, false},
+		{[]string{"-buffersize", "16384"}, 16384, false},
+		{[]string{"-buffersize", "0"}, 0, false},
+		{[]string{"-buffersize", "1000"}, 0, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		result := Config{}
+		err := Parse(&result)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		if result.BufferSize != table.expected {
+			t.Errorf("Expected buffer size %v but got %v instead", table.expected, result.BufferSize)
+		}
+	}
+
+	// A malformed tag is reported before anything is parsed.
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	invalid := struct {
+		BufferSize int `multipleof:"-1"`
+	}{}
+	if err := Parse(&invalid); err == nil {
+		t.Error("Expected an error for an invalid multipleof tag but did not get it")
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestGroupAllOrNone(t *testing.T) {
+	type Config struct {
+		TLSCert string `group:"tls" grouppolicy:"allornone"`
+		TLSKey  string `group:"tls"`
+		Port    int
+	}
+
+	tables := []struct {
+		flags []string
+		isErr bool
+	}{
+		{[]string{}, false},
+		{[]string{"-tlscert", "cert.pem", "-tlskey", "key.pem"}, false},
+		{[]string{"-tlscert", "cert.pem"}, true},
+		{[]string{"-tlskey", "key.pem", "-port", "443"}, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		result := Config{}
+		err := Parse(&result)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestPathMustExist(t *testing.T) {
+	type Config struct {
+		CertFile string `path:"mustexist,readable"`
+		KeyFile  string `path:"mustexist"`
+	}
+
+	dir, err := os.MkdirTemp("", "configparser-test")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	existing := filepath.Join(dir, "cert.pem")
+	if err := os.WriteFile(existing, []byte("cert"), 0644); err != nil {
+		t.Fatalf("Could not create file: %v", err)
+	}
+	missing := filepath.Join(dir, "missing.pem")
+
+	tables := []struct {
+		flags []string
+		isErr bool
+	}{
+		{[]string{}, false},
+		{[]string{"-certfile", existing, "-keyfile", dir}, false},
+		{[]string{"-certfile", missing}, true},
+		{[]string{"-keyfile", missing}, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		result := Config{}
+		err := Parse(&result)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+
+	// A default which doesn't exist is only an error if nothing overrides
+	// it.
+	type WithDefault struct {
+		Cert string `default:"/nonexistent/app.pem" path:"mustexist"`
+	}
+	defaultTables := []struct {
+		env   map[string]string
+		args  []string
+		isErr bool
+	}{
+		{map[string]string{"CERT": existing}, []string{}, false},
+		{map[string]string{}, []string{"-cert", existing}, false},
+		{map[string]string{}, []string{}, true},
+	}
+	for index, table := range defaultTables {
+		t.Logf("Testing default table %d", index)
+		pr := deterministicParser("", table.env, table.args, Options{})
+
+		result := WithDefault{}
+		err := pr.Parse(&result)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		} else if result.Cert != existing {
+			t.Errorf("Expected %s but got %s instead", existing, result.Cert)
+		}
+	}
+
+	// The path tag only applies to strings.
+	setFlags([]string{})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	invalid := struct {
+		Port int `path:"mustexist"`
+	}{}
+	if err := Parse(&invalid); err == nil {
+		t.Error("Expected an error for a path tag on an int but did not get it")
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestValueConstraints(t *testing.T) {
+	type Config struct {
+		Port    int           `min:"1" max:"65535" default:"8080" noenv:"true"`
+		Ratio   float64       `min:"0" max:"1" noenv:"true"`
+		Timeout time.Duration `min:"1s" noenv:"true"`
+		Level   string        `oneof:"debug, info, warn" default:"info" noenv:"true"`
+		Region  string        `pattern:"[a-z]+-[0-9]" noenv:"true"`
+	}
+
+	tables := []struct {
+		flags []string
+		isErr bool
+	}{
+		{[]string{}, false},
+		{[]string{"-port", "1", "-ratio", "0.5", "-timeout", "1s", "-level", "warn", "-region", "eu-1"}, false},
+		{[]string{"-port", "0"}, true},
+		{[]string{"-port", "65536"}, true},
+		{[]string{"-ratio", "1.5"}, true},
+		{[]string{"-timeout", "500ms"}, true},
+		{[]string{"-level", "trace"}, true},
+		{[]string{"-region", "eu-1x"}, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		result := Config{}
+		err := Parse(&result)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+
+	// A default which exceeds the field's max is reported before anything
+	// else is parsed.
+	setFlags([]string{"-port", "80"})
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	invalid := struct {
+		Port int `max:"1024" default:"8080" noenv:"true"`
+	}{}
+	err := Parse(&invalid)
+	if err == nil || !strings.Contains(err.Error(), "default value") {
+		t.Errorf("Expected an error for a default which exceeds its max but got %v instead", err)
+	}
+
+	// So is a default of the wrong type, even if another source would
+	// override it.
+	wrongType := struct {
+		Port int `default:"abc"`
+	}{}
+	err = ParseDeterministic(&wrongType, "", map[string]string{"PORT": "80"}, nil)
+	if err == nil || !strings.Contains(err.Error(), `default value "abc" for field Port is invalid`) {
+		t.Errorf("Expected an error for a default of the wrong type but got %v instead", err)
+	}
+
+	// Constraints are only allowed on fields of suitable types.
+	for _, invalid := range []interface{}{
+		&struct {
+			Name string `max:"1"`
+		}{},
+		&struct {
+			Port int `pattern:"[0-9]+"`
+		}{},
+		&struct {
+			Port int `min:"10" max:"1"`
+		}{},
+	} {
+		setFlags([]string{})
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		if err := Parse(invalid); err == nil {
+			t.Errorf("Expected an error for %T but did not get it", invalid)
+		}
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestItemCount(t *testing.T) {
+	type Config struct {
+		Seeds []string `minitems:"1" maxitems:"5" noenv:"true"`
+		Peers []string `minitems:"2" mandatory:"true" noenv:"true"`
+	}
+
+	tables := []struct {
+		flags []string
+		isErr bool
+	}{
+		{[]string{"-peers", "a,b"}, false},
+		{[]string{"-peers", "a,b", "-seeds", "a"}, false},
+		{[]string{"-peers", "a,b", "-seeds", "a,b,c,d,e"}, false},
+		{[]string{"-peers", "a,b", "-seeds", "a,b,c,d,e,f"}, true},
+		{[]string{"-peers", "a"}, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		result := Config{}
+		err := Parse(&result)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else if !strings.Contains(err.Error(), "field") || !strings.Contains(err.Error(), "items") {
+				t.Errorf("Expected an error naming the field and bounds but got: %v", err)
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+
+	// The tags are only allowed on slices, with sensible bounds.
+	for _, invalid := range []interface{}{
+		&struct {
+			Name string `minitems:"1"`
+		}{},
+		&struct {
+			Seeds []string `maxitems:"-1"`
+		}{},
+		&struct {
+			Seeds []string `minitems:"5" maxitems:"1"`
+		}{},
+	} {
+		setFlags([]string{})
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		if err := Parse(invalid); err == nil {
+			t.Errorf("Expected an error for %T but did not get it", invalid)
+		}
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestChecksum(t *testing.T) {
+	type Config struct {
+		Token       string `checksum:"sha256:TokenSHA256" noenv:"true"`
+		TokenSHA256 string `flag:"tokensha256" noenv:"true"`
+	}
+
+	// The SHA-256 checksum of "secret".
+	sum := "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"
+
+	tables := []struct {
+		flags []string
+		isErr bool
+	}{
+		{[]string{}, false},
+		{[]string{"-token", "secret", "-tokensha256", sum}, false},
+		{[]string{"-token", "secret", "-tokensha256", strings.ToUpper(sum)}, false},
+		{[]string{"-token", "secreT", "-tokensha256", sum}, true},
+		{[]string{"-token", "secret"}, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		result := Config{}
+		err := Parse(&result)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+
+	// The tag must name a known hash function and another string field.
+	for _, invalid := range []interface{}{
+		&struct {
+			Token string `checksum:"crc32:Sum"`
+			Sum   string
+		}{},
+		&struct {
+			Token string `checksum:"sha256:Missing"`
+		}{},
+		&struct {
+			Token string `checksum:"sha256:Sum"`
+			Sum   int
+		}{},
+		&struct {
+			Count int `checksum:"sha256:Sum"`
+			Sum   string
+		}{},
+	} {
+		setFlags([]string{})
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		if err := Parse(invalid); err == nil {
+			t.Errorf("Expected an error for %T but did not get it", invalid)
+		}
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestEqualTo(t *testing.T) {
+	type Config struct {
+		Password        string `noenv:"true"`
+		ConfirmPassword string `flag:"confirm" noenv:"true" equalto:"Password"`
+		Port            int    `noenv:"true"`
+		ConfirmPort     int    `flag:"confirmport" noenv:"true" equalto:"Port"`
+		TLS             bool   `noenv:"true"`
+		ConfirmTLS      bool   `flag:"confirmtls" noenv:"true" equalto:"TLS"`
+	}
+
+	tables := []struct {
+		flags []string
+		isErr bool
+	}{
+		{[]string{}, false},
+		{[]string{"-password", "secret", "-confirm", "secret"}, false},
+		{[]string{"-port", "8080", "-confirmport", "8080", "-tls", "-confirmtls"}, false},
+		{[]string{"-password", "secret", "-confirm", "secreT"}, true},
+		{[]string{"-password", "secret"}, true},
+		{[]string{"-port", "8080", "-confirmport", "8081"}, true},
+		{[]string{"-tls"}, true},
+	}
+
+	for index, table := range tables {
+		t.Logf("Testing table %d", index)
+		setFlags(table.flags)
+
+		// Needed because we are calling flag.Parse() each time we run a test.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		result := Config{}
+		err := Parse(&result)
+		if table.isErr {
+			if err == nil {
+				t.Error("Expected an error but did not get it")
+			} else {
+				t.Logf("Expected an error - got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+
+	// The tag must name another field of the same type.
+	for _, invalid := range []interface{}{
+		&struct {
+			Confirm string `equalto:"Missing"`
+		}{},
+		&struct {
+			Password string
+			Confirm  int `equalto:"Password"`
+		}{},
+		&struct {
+			Confirm string `equalto:"Confirm"`
+		}{},
+		&struct {
+			Timeout time.Time `equalto:"Other"`
+			Other   time.Time
+		}{},
+	} {
+		setFlags([]string{})
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		if err := Parse(invalid); err == nil {
+			t.Errorf("Expected an error for %T but did not get it", invalid)
+		}
+	}
+
+	// Needed because we are calling flag.Parse() each time we run a test.
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}